@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"log"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// APIKeyInterceptor is a client interceptor authenticating with an API key,
+// for services calling the API without a user account.
+type APIKeyInterceptor struct {
+	apiKey      string
+	authMethods map[string]bool
+}
+
+// NewAPIKeyInterceptor returns a new APIKeyInterceptor instance.
+func NewAPIKeyInterceptor(apiKey string, authMethods map[string]bool) *APIKeyInterceptor {
+	return &APIKeyInterceptor{apiKey, authMethods}
+}
+
+// Unary return a client interceptor to authenticate unary RPC.
+func (interceptor *APIKeyInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		log.Printf("---> unary interceptor: %s", method)
+
+		if interceptor.authMethods[method] {
+			return invoker(interceptor.attachKey(ctx), method, req, reply, cc, opts...)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream return a client interceptor to authenticate stream RPC.
+func (interceptor *APIKeyInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		log.Printf("---> stream interceptor: %s", method)
+		if interceptor.authMethods[method] {
+			return streamer(interceptor.attachKey(ctx), desc, cc, method, opts...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (interceptor *APIKeyInterceptor) attachKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, service.APIKeyHeader, interceptor.apiKey)
+}
@@ -21,8 +21,9 @@ func NewAuthClient(cc *grpc.ClientConn, username, password string) *AuthClient {
 	return &AuthClient{service, username, password}
 }
 
-// Login authenticates a client with username and password
-func (client *AuthClient) Login() (string, error) {
+// Login authenticates a client with username and password, and returns an access token
+// and a refresh token
+func (client *AuthClient) Login() (string, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -33,8 +34,39 @@ func (client *AuthClient) Login() (string, error) {
 
 	res, err := client.service.Login(ctx, req)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return res.GetAccessToken(), nil
+	return res.GetAccessToken(), res.GetRefreshToken(), nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token
+func (client *AuthClient) Refresh(refreshToken string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	}
+
+	res, err := client.service.RefreshToken(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	return res.GetAccessToken(), res.GetRefreshToken(), nil
+}
+
+// Logout revokes the access token and the refresh token
+func (client *AuthClient) Logout(accessToken, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.LogoutRequest{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+
+	_, err := client.service.Logout(ctx, req)
+	return err
 }
@@ -3,17 +3,35 @@ package client
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/IkehAkinyemi/pcbook/service"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // AuthInterceptor is a client interceptor for authentication.
 type AuthInterceptor struct {
 	authClient  *AuthClient
 	authMethods map[string]bool
-	accessToken string
+
+	mutex        sync.RWMutex
+	accessToken  string
+	refreshToken string
+}
+
+// AuthMethods returns the RPCs that require an access token, as declared by the auth
+// option in the proto files.
+func AuthMethods() map[string]bool {
+	authMethods := make(map[string]bool)
+	for method, rule := range service.MethodPolicies(protoregistry.GlobalFiles) {
+		if !rule.Public {
+			authMethods[method] = true
+		}
+	}
+	return authMethods
 }
 
 // NewAuthInterceptor returns a new AuthInterceptor instance.
@@ -36,7 +54,7 @@ func NewAuthInterceptor(
 }
 
 func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Duration) error {
-	err := interceptor.refreshToken()
+	err := interceptor.refreshTokens()
 	if err != nil {
 		return err
 	}
@@ -45,7 +63,7 @@ func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Du
 		wait := refreshDuration
 		for {
 			time.Sleep(wait)
-			err := interceptor.refreshToken()
+			err := interceptor.refreshTokens()
 			if err != nil {
 				wait = time.Second
 			} else {
@@ -95,16 +113,48 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
 	}
 }
 
+// Logout revokes the tokens of the interceptor.
+func (interceptor *AuthInterceptor) Logout() error {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return interceptor.authClient.Logout(interceptor.accessToken, interceptor.refreshToken)
+}
+
 func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
-	return metadata.AppendToOutgoingContext(ctx, "authorization", interceptor.accessToken)
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+interceptor.accessToken)
 }
 
-func (interceptor *AuthInterceptor) refreshToken() error {
-	accessToken, err := interceptor.authClient.Login()
-	if err != nil {
-		return err
+// refreshTokens gets new tokens with the refresh token, and logs in again with
+// the username and password only when there is no usable refresh token.
+func (interceptor *AuthInterceptor) refreshTokens() error {
+	interceptor.mutex.RLock()
+	refreshToken := interceptor.refreshToken
+	interceptor.mutex.RUnlock()
+
+	var accessToken string
+	var err error
+	if refreshToken != "" {
+		accessToken, refreshToken, err = interceptor.authClient.Refresh(refreshToken)
+		if err != nil {
+			log.Printf("cannot refresh token, logging in again: %v", err)
+		}
 	}
 
+	if refreshToken == "" || err != nil {
+		accessToken, refreshToken, err = interceptor.authClient.Login()
+		if err != nil {
+			return err
+		}
+	}
+
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
 	interceptor.accessToken = accessToken
+	interceptor.refreshToken = refreshToken
 	return nil
 }
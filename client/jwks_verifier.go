@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// minJWKSRefreshInterval limits how often a JWKSVerifier refetches the key set
+// when it sees a token signed with an unknown key.
+const minJWKSRefreshInterval = 10 * time.Second
+
+// JWKSVerifier verifies access tokens with the keys published by the server
+// as a JSON web key set.
+type JWKSVerifier struct {
+	url           string
+	httpClient    *http.Client
+	cacheDuration time.Duration
+	issuer        string
+	audience      string
+	leeway        time.Duration
+
+	mutex     sync.Mutex
+	keys      map[string]service.JSONWebKey
+	fetchedAt time.Time
+}
+
+// NewJWKSVerifier returns a JWKSVerifier fetching the key set from url,
+// and keeping it for cacheDuration.
+func NewJWKSVerifier(url string, cacheDuration time.Duration) *JWKSVerifier {
+	return &JWKSVerifier{
+		url:           url,
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+		cacheDuration: cacheDuration,
+		issuer:        service.DefaultJWTIssuer,
+		audience:      service.DefaultJWTAudience,
+		leeway:        service.DefaultJWTLeeway,
+	}
+}
+
+// SetIssuer sets the expected issuer and audience claims of the tokens.
+func (verifier *JWKSVerifier) SetIssuer(issuer, audience string) {
+	verifier.issuer = issuer
+	verifier.audience = audience
+}
+
+// Verify verifies the given access token and returns the claims if the token is valid.
+func (verifier *JWKSVerifier) Verify(accessToken string) (*service.UserClaims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	token, err := parser.ParseWithClaims(
+		accessToken,
+		&service.UserClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			keyID, _ := token.Header["kid"].(string)
+			jwk, err := verifier.key(keyID)
+			if err != nil {
+				return nil, err
+			}
+
+			if token.Method.Alg() != jwk.Algorithm {
+				return nil, fmt.Errorf("unexpected token signing method: %s", token.Method.Alg())
+			}
+
+			return jwk.PublicKey()
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	claims, ok := token.Claims.(*service.UserClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	err = claims.Validate(verifier.issuer, verifier.audience, verifier.leeway, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	if claims.TokenType != service.AccessTokenType {
+		return nil, fmt.Errorf("invalid token type: %q", claims.TokenType)
+	}
+
+	return claims, nil
+}
+
+// key returns the key of the given ID, fetching the key set again when the cached one
+// is stale, or when it does not contain the key because the server rotated its keys.
+func (verifier *JWKSVerifier) key(keyID string) (service.JSONWebKey, error) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
+	age := time.Since(verifier.fetchedAt)
+	jwk, ok := verifier.keys[keyID]
+	if age > verifier.cacheDuration || (!ok && age > minJWKSRefreshInterval) {
+		err := verifier.fetch()
+		if err != nil {
+			return service.JSONWebKey{}, err
+		}
+		jwk, ok = verifier.keys[keyID]
+	}
+
+	if !ok {
+		return service.JSONWebKey{}, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	return jwk, nil
+}
+
+func (verifier *JWKSVerifier) fetch() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, verifier.url, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create JWKS request: %w", err)
+	}
+
+	res, err := verifier.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot fetch JWKS: unexpected response status: %s", res.Status)
+	}
+
+	keySet := service.JSONWebKeySet{}
+	err = json.NewDecoder(res.Body).Decode(&keySet)
+	if err != nil {
+		return fmt.Errorf("cannot decode JWKS: %w", err)
+	}
+
+	verifier.keys = make(map[string]service.JSONWebKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		verifier.keys[jwk.KeyID] = jwk
+	}
+	verifier.fetchedAt = time.Now()
+	return nil
+}
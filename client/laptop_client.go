@@ -79,6 +79,10 @@ func (client LaptopClient) SearchLaptop(filter *pb.Filter) {
 		fmt.Println("  + cpu min ghz: ", laptop.GetCpu().GetMinGhz())
 		fmt.Println("  + ram: ", laptop.GetRam(), laptop.GetRam().GetUnit())
 		fmt.Println("  + price: ", laptop.GetPriceUsd(), "USD")
+		if rating := res.GetRating(); rating.GetRatingCount() > 0 {
+			fmt.Printf("  + rating: %.2f (%d ratings, bayesian %.2f)\n",
+				rating.GetAverageScore(), rating.GetRatingCount(), rating.GetBayesianScore())
+		}
 	}
 }
 
@@ -169,6 +173,10 @@ func (client LaptopClient) RateLaptop(laptopIDs []string, scores []float64) erro
 				waitReponse <- fmt.Errorf("cannot receive stream response: %v", err)
 				return
 			}
+			if res.GetError() != nil {
+				log.Printf("cannot rate laptop %s: %s", res.GetLaptopId(), res.GetError().GetMessage())
+				continue
+			}
 			log.Print("received response: ", res)
 		}
 	}()
@@ -196,3 +204,45 @@ func (client LaptopClient) RateLaptop(laptopIDs []string, scores []float64) erro
 	err = <-waitReponse
 	return err
 }
+
+// WatchRatings prints the rating updates of the laptops until the context is done.
+func (client LaptopClient) WatchRatings(ctx context.Context, laptopIDs []string) error {
+	req := &pb.WatchRatingsRequest{LaptopIds: laptopIDs}
+	stream, err := client.service.WatchRatings(ctx, req)
+	if err != nil {
+		return fmt.Errorf("cannot watch ratings: %v", err)
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF || status.Code(err) == codes.Canceled {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot receive rating update: %v", err)
+		}
+		log.Printf("laptop %s is rated %.2f (%d ratings)", res.GetLaptopId(), res.GetAverageScore(), res.GetRatingCount())
+	}
+}
+
+// WatchLaptops prints the changes to the laptop catalog following the given sequence number,
+// until the context is done. It returns the sequence number of the last received change.
+func (client LaptopClient) WatchLaptops(ctx context.Context, fromSequence uint64) (uint64, error) {
+	req := &pb.WatchLaptopsRequest{FromSequence: fromSequence}
+	stream, err := client.service.WatchLaptops(ctx, req)
+	if err != nil {
+		return fromSequence, fmt.Errorf("cannot watch laptops: %v", err)
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF || status.Code(err) == codes.Canceled {
+			return fromSequence, nil
+		}
+		if err != nil {
+			return fromSequence, fmt.Errorf("cannot receive laptop event: %v", err)
+		}
+		log.Printf("#%d laptop %s %v", event.GetSequence(), event.GetLaptopId(), event.GetType())
+		fromSequence = event.GetSequence()
+	}
+}
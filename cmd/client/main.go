@@ -20,13 +20,15 @@ import (
 
 const (
 	username        = "admin1"
-	password        = "secret"
+	password        = "pcbook-secret"
 	refreshDuration = 1 * time.Minute
 )
 
 func main() {
 	address := flag.String("srv-addr", "", "server address to dial")
 	enableTLS := flag.Bool("tls", false, "enable SSL/TLS")
+	apiKey := flag.String("api-key", "", "API key to authenticate with, in place of the username and password")
+	certAuth := flag.Bool("cert-auth", false, "authenticate with the client certificate only (requires -tls)")
 	flag.Parse()
 	log.Printf("dialing server %s, with TLS = %t", *address, *enableTLS)
 
@@ -40,22 +42,35 @@ func main() {
 		dialOption = grpc.WithTransportCredentials(tlsCreds)
 	}
 
-	cc1, err := grpc.Dial(*address, dialOption)
-	if err != nil {
-		log.Fatalf("error occurred dialing address: %v", err)
-	}
-	authClient := client.NewAuthClient(cc1, username, password)
-	authInterceptor, err := client.NewAuthInterceptor(authClient, authMethods(), refreshDuration)
-	if err != nil {
-		log.Fatalf("cannot create auth interceptor: %v", err)
+	dialOptions := []grpc.DialOption{dialOption}
+	switch {
+	case *certAuth:
+		if !*enableTLS {
+			log.Fatal("authenticating with the client certificate requires TLS")
+		}
+	case *apiKey != "":
+		apiKeyInterceptor := client.NewAPIKeyInterceptor(*apiKey, client.AuthMethods())
+		dialOptions = append(dialOptions,
+			grpc.WithUnaryInterceptor(apiKeyInterceptor.Unary()),
+			grpc.WithStreamInterceptor(apiKeyInterceptor.Stream()),
+		)
+	default:
+		cc1, err := grpc.Dial(*address, dialOption)
+		if err != nil {
+			log.Fatalf("error occurred dialing address: %v", err)
+		}
+		authClient := client.NewAuthClient(cc1, username, password)
+		authInterceptor, err := client.NewAuthInterceptor(authClient, client.AuthMethods(), refreshDuration)
+		if err != nil {
+			log.Fatalf("cannot create auth interceptor: %v", err)
+		}
+		dialOptions = append(dialOptions,
+			grpc.WithUnaryInterceptor(authInterceptor.Unary()),
+			grpc.WithStreamInterceptor(authInterceptor.Stream()),
+		)
 	}
 
-	cc2, err := grpc.Dial(
-		*address,
-		dialOption,
-		grpc.WithUnaryInterceptor(authInterceptor.Unary()),
-		grpc.WithStreamInterceptor(authInterceptor.Stream()),
-	)
+	cc2, err := grpc.Dial(*address, dialOptions...)
 	if err != nil {
 		log.Fatalf("error occurred dialing address: %v", err)
 	}
@@ -64,16 +79,6 @@ func main() {
 	testRateLaptop(laptopClient)
 }
 
-func authMethods() map[string]bool {
-	laptopServerPath := "/LaptopService/"
-
-	return map[string]bool{
-		laptopServerPath + "CreateLaptop": true,
-		laptopServerPath + "RateLaptop":   true,
-		laptopServerPath + "UploadImage":  true,
-	}
-}
-
 func testCreateLaptop(laptopClient *client.LaptopClient) {
 	laptopClient.CreateLaptop(sample.NewLaptop())
 }
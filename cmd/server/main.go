@@ -3,71 +3,320 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/IkehAkinyemi/pcbook/config"
+	"github.com/IkehAkinyemi/pcbook/gateway"
 	"github.com/IkehAkinyemi/pcbook/pb"
 	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/IkehAkinyemi/pcbook/swagger"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
-)
-
-const (
-	secretKey     = "secret-xxxx"
-	tokenDuration = 15 * time.Minute
-)
-
-const (
-	serverCertFile = "cert/server-cert.pem"
-	serverKeyFile = "cert/server-key.pem"
-	clientCACertFile = "cert/ca-cert.pem"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 func main() {
-	port := flag.Int("port", 0, "server port value")
-	enableTLS := flag.Bool("tls", false, "enable SSL/TLS")
-	serverType := flag.String("srv-type", "grpc", "type of server (grpc/rest)")
-	endpoint := flag.String("grpc-endpoint", "", "gRPC endpoint")
+	cfg := config.Default()
+	cfg.RegisterFlags(flag.CommandLine)
+	configFile := flag.String("config", "", "YAML configuration file, overridden by the "+config.EnvPrefix+"_* environment variables and by the flags")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, with the secrets redacted, and exit")
 	flag.Parse()
 
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	logger, err := newLogger(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	slog.Debug("effective configuration", "file", *configFile, "config", cfg.String())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tracerProvider, err := newTracerProvider(cfg.Tracing.Exporter, cfg.Tracing.OTLPEndpoint, "pcbook-"+cfg.Server.Type, cfg.Tracing.SampleRatio)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tracerProvider.Shutdown(context.Background())
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	scale, err := service.ParseRatingScale(cfg.Ratings.Scale)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prior := service.DefaultRatingPrior(scale)
+	prior.Weight = cfg.Ratings.PriorWeight
+	if cfg.Ratings.PriorMean != nil {
+		prior.Mean = *cfg.Ratings.PriorMean
+	}
+
 	userStore := service.NewInMemoryUserStore()
-	err := seedUsers(userStore)
+
+	privateKey, publicKey, verificationKeys, err := readKeys(cfg.JWT)
 	if err != nil {
-		log.Fatal("cannot seed users")
+		log.Fatal(err)
 	}
 
-	privateKey, publicKey, err := readInConfig("./keys/")
+	jwtManager, err := service.NewJWTManager(privateKey, publicKey, cfg.JWT.TokenDuration, cfg.JWT.RefreshDuration)
 	if err != nil {
 		log.Fatal(err)
 	}
+	jwtManager.SetIssuer(cfg.JWT.Issuer, cfg.JWT.Audience)
+	jwtManager.SetLeeway(cfg.JWT.Leeway)
 
-	jwtManager := service.NewJWTManager(privateKey, publicKey, tokenDuration)
-	authServer := service.NewAuthServer(userStore, jwtManager)
+	for i, publicKey := range verificationKeys {
+		err = jwtManager.AddVerificationKey(publicKey)
+		if err != nil {
+			log.Fatalf("cannot add verification key %s: %v", cfg.JWT.VerificationKeyFiles[i], err)
+		}
+	}
+	revocationStore := service.NewInMemoryRevocationStore()
+
+	policy, err := loadPolicy(cfg.Policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authServer := service.NewAuthServer(userStore, jwtManager, revocationStore)
+	authServer.SetPolicy(policy)
+
+	passwordHasher := service.DefaultPasswordHasher
+	switch cfg.Passwords.Hash {
+	case "bcrypt":
+		passwordHasher = service.BcryptHasher{Cost: cfg.Passwords.BcryptCost}
+	case "argon2id":
+		passwordHasher = service.DefaultArgon2idHasher
+	}
+	authServer.SetPasswordHasher(passwordHasher)
+
+	passwordPolicy := service.DefaultPasswordPolicy
+	passwordPolicy.MinLength = cfg.Passwords.MinLength
+	passwordPolicy.RequiredClasses = cfg.Passwords.RequiredClasses
+	if cfg.Passwords.BlocklistFile != "" {
+		err = passwordPolicy.LoadBlocklist(cfg.Passwords.BlocklistFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	authServer.SetPasswordPolicy(&passwordPolicy)
+
+	err = seedUsers(userStore, cfg.Users, policy, &passwordPolicy, passwordHasher)
+	if err != nil {
+		log.Fatalf("cannot seed users: %v", err)
+	}
+
+	tenants, err := service.NewTenantSet(cfg.Tenants...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authServer.SetTenants(tenants)
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("img")
+	imageStore := service.NewDiskImageStore(cfg.Stores.ImageFolder)
 	ratingStore := service.NewInMemoryRatingStore()
+	ratingStore.SetHalfLife(cfg.Ratings.HalfLife)
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	laptopServer.SetRatingScale(scale)
+	laptopServer.SetRatingPrior(prior)
+	laptopServer.SetMaxImageSize(cfg.Images.MaxSize)
+	laptopServer.SetQuotas(service.TenantQuotas{
+		MaxLaptops:       cfg.Quotas.MaxLaptops,
+		DailyUploadBytes: cfg.Quotas.DailyUploadBytes,
+	})
+	laptopServer.SetTenants(tenants)
+	laptopServer.SetTenantStoreFactory(func(tenant string) (service.LaptopStore, service.ImageStore, service.RatingStore, error) {
+		imageFolder := filepath.Join(cfg.Stores.ImageFolder, tenant)
+		err := os.MkdirAll(imageFolder, 0o755)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		ratingStore := service.NewInMemoryRatingStore()
+		ratingStore.SetHalfLife(cfg.Ratings.HalfLife)
+		return service.NewInMemoryLaptopStore(), service.NewDiskImageStore(imageFolder), ratingStore, nil
+	})
+
+	webhookStore := service.NewInMemoryWebhookStore()
+	webhookDispatcher := service.NewWebhookDispatcher(webhookStore, service.DefaultWebhookOptions)
+	webhookServer := service.NewWebhookServer(webhookStore, webhookDispatcher)
+
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	apiKeyServer := service.NewAPIKeyServer(apiKeyStore, policy)
+
+	auditStore := service.NewInMemoryAuditStore(service.DefaultAuditRetention)
+	auditSinks := []service.AuditSink{auditStore}
+	if cfg.Audit.LogFile != "" {
+		auditFile, err := service.NewFileAuditSink(cfg.Audit.LogFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditFile.Close()
+		auditSinks = append(auditSinks, auditFile)
+	}
+	auditInterceptor := service.NewAuditInterceptor(service.AuditedMethods(protoregistry.GlobalFiles), auditSinks...)
+	auditServer := service.NewAuditServer(auditStore)
+
+	var tlsReloader *service.TLSReloader
+	if cfg.TLS.Enabled {
+		tlsReloader, err = service.NewTLSReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("cannot load TLS credentials: %v", err)
+		}
+	}
 
-	address := fmt.Sprintf(":%d", *port)
+	var certIdentities *service.CertificateIdentities
+	if cfg.TLS.CertIdentitiesFile != "" {
+		certIdentities, err = service.LoadCertificateIdentities(cfg.TLS.CertIdentitiesFile, tenants)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	authInterceptor := service.NewAuthInterceptor(jwtManager, revocationStore, policy)
+	authInterceptor.SetAPIKeyStore(apiKeyStore)
+	authInterceptor.SetUserStore(userStore)
+	authInterceptor.SetTenants(tenants)
+	if cfg.TLS.Enabled && certIdentities != nil {
+		authInterceptor.SetCertificateIdentities(certIdentities)
+	}
+
+	rateLimiter := service.NewRateLimiter(service.RateLimit(cfg.RateLimits.Default))
+	for method, limit := range cfg.RateLimits.Methods {
+		err = rateLimiter.SetMethodLimit(method, service.RateLimit(limit))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// the files are reloaded without a restart, so that the live connections are kept,
+	// on SIGHUP or when they change
+	var reloadMutex sync.Mutex
+	reload := func() {
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
+
+		if tlsReloader != nil {
+			logReload("tls", tlsReloader.Reload())
+		}
+
+		logReload("jwt", reloadKeys(jwtManager, cfg.JWT))
+
+		policy, err := loadPolicy(cfg.Policy)
+		if err == nil {
+			authInterceptor.SetPolicy(policy)
+			authServer.SetPolicy(policy)
+			apiKeyServer.SetPolicy(policy)
+		}
+		logReload("policy", err)
+	}
+	watchReloads(ctx, cfg.Server.ReloadInterval, reloadedFiles(cfg, tlsReloader), reload)
+	laptopServer.SetWebhookDispatcher(webhookDispatcher)
+	webhookDispatcher.WatchLaptopFeed(context.Background(), laptopServer.LaptopFeed())
+
+	address := fmt.Sprintf(":%d", cfg.Server.Port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		 log.Fatalf("cannot connect tcp listener: %v", err)
 	}
 
-	if *serverType == "grpc" {
-		err = runGRPCServer(authServer, laptopServer, jwtManager, *enableTLS, listener)
-	} else {
-		err = runRESTServer(authServer, laptopServer, jwtManager, *enableTLS, listener, *endpoint)
+	healthServer := health.NewServer()
+	healthMonitor := service.NewHealthMonitor(
+		healthServer,
+		pb.AuthService_ServiceDesc.ServiceName,
+		pb.LaptopService_ServiceDesc.ServiceName,
+		pb.WebhookService_ServiceDesc.ServiceName,
+		pb.APIKeyService_ServiceDesc.ServiceName,
+		pb.AuditService_ServiceDesc.ServiceName,
+	)
+	healthMonitor.AddCheck("laptop_stores", laptopServer)
+
+	// the keys are loaded and the users seeded: the server is ready once it serves its
+	// listeners and the stores are available
+	ready := func() {
+		healthMonitor.SetReady(true)
+	}
+
+	// the server stops reporting as serving, and ends the watch streams that would
+	// never end by themselves, so that the drain does not wait for its timeout. The
+	// streams opened meanwhile are closed at the timeout.
+	shutdown := func() {
+		healthMonitor.SetReady(false)
+		healthMonitor.Shutdown()
+		laptopServer.Shutdown()
+	}
+
+	newGRPCServer := func(creds credentials.TransportCredentials) *grpc.Server {
+		metrics := service.NewMetrics(registry)
+		laptopServer.SetMetrics(metrics)
+		healthMonitor.Watch(ctx, cfg.Health.Interval)
+
+		grpcServer := grpc.NewServer(grpcServerOptions(logger, metrics, tracerProvider, auditInterceptor, authInterceptor, rateLimiter, creds)...)
+		pb.RegisterAuthServiceServer(grpcServer, authServer)
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		pb.RegisterWebhookServiceServer(grpcServer, webhookServer)
+		pb.RegisterAPIKeyServiceServer(grpcServer, apiKeyServer)
+		pb.RegisterAuditServiceServer(grpcServer, auditServer)
+		healthpb.RegisterHealthServer(grpcServer, healthServer)
+		reflection.Register(grpcServer)
+		return grpcServer
+	}
+
+	switch cfg.Server.Type {
+	case "grpc":
+		var creds credentials.TransportCredentials
+		if tlsReloader != nil {
+			creds = credentials.NewTLS(tlsReloader.Config(&tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				NextProtos: []string{"h2"},
+			}))
+		}
+		if cfg.Server.MetricsPort != 0 {
+			go runMetricsServer(registry, cfg.Server.MetricsPort)
+		}
+		err = runGRPCServer(ctx, newGRPCServer(creds), ready, shutdown, cfg.TLS.Enabled, listener, cfg.Server.DrainTimeout)
+	case "rest":
+		err = runRESTServer(ctx, registry, tracerProvider, jwtManager, tlsReloader, listener, cfg.Server.GRPCEndpoint, cfg.Server.DrainTimeout)
+	case "all":
+		err = runServer(ctx, newGRPCServer(nil), ready, shutdown, registry, tracerProvider, jwtManager, tlsReloader, listener, cfg.Server.DrainTimeout)
 	}
 
 	if err != nil {
@@ -75,139 +324,542 @@ func main() {
 	}
 }
 
+// grpcServerOptions returns the options of the gRPC server, with its interceptors
+// and its transport credentials, if any.
+func grpcServerOptions(
+	logger *slog.Logger,
+	metrics *service.Metrics,
+	tracerProvider *sdktrace.TracerProvider,
+	auditInterceptor *service.AuditInterceptor,
+	authInterceptor *service.AuthInterceptor,
+	rateLimiter *service.RateLimiter,
+	creds credentials.TransportCredentials,
+) []grpc.ServerOption {
+	loggingInterceptor := service.NewLoggingInterceptor(logger)
+	tracingInterceptor := service.NewTracingInterceptor(tracerProvider, otel.GetTextMapPropagator())
+	serverOption := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			tracingInterceptor.Unary(),
+			metrics.Unary(),
+			loggingInterceptor.Unary(),
+			auditInterceptor.Unary(),
+			authInterceptor.Unary(),
+			rateLimiter.Unary(),
+		),
+		grpc.ChainStreamInterceptor(
+			tracingInterceptor.Stream(),
+			metrics.Stream(),
+			loggingInterceptor.Stream(),
+			auditInterceptor.Stream(),
+			authInterceptor.Stream(),
+			rateLimiter.Stream(),
+		),
+	}
+
+	if creds != nil {
+		serverOption = append(serverOption, grpc.Creds(creds))
+	}
+	return serverOption
+}
+
+// runGRPCServer serves the gRPC server on the listener, calling ready once it serves,
+// until the context is done, then calls shutdown and drains the server.
 func runGRPCServer(
-	authServer *service.AuthServer,
-	laptopServer *service.LaptopServer,
-	jwtManager *service.JWTManager,
+	ctx context.Context,
+	grpcServer *grpc.Server,
+	ready func(),
+	shutdown func(),
 	enableTLS bool,
 	listener net.Listener,
+	drainTimeout time.Duration,
 ) error {
-	interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
-	serverOption := []grpc.ServerOption{
-		grpc.UnaryInterceptor(interceptor.Unary()),
-		grpc.StreamInterceptor(interceptor.Stream()),
+	slog.Info("start gRPC server", "address", listener.Addr().String(), "tls", enableTLS)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- grpcServer.Serve(listener)
+	}()
+	ready()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
 	}
 
-	if enableTLS {
-		tlsCredentials, err := loadTLSCredentials()
-		if err != nil {
-			return fmt.Errorf("cannot load TLS credentials: %w", err)
-		}
-		serverOption = append(serverOption, grpc.Creds(tlsCredentials))
+	slog.Info("shutting down gRPC server", "drain_timeout", drainTimeout)
+	shutdown()
+	stopGRPCServer(grpcServer, drainTimeout)
+	return nil
+}
+
+// stopGRPCServer stops the server gracefully, closing the connections that are
+// still open after the drain timeout. The server must only serve its own listeners:
+// GracefulStop panics on the requests served through ServeHTTP, which are drained by
+// the gateway.Multiplexer instead.
+func stopGRPCServer(grpcServer *grpc.Server, drainTimeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(drainTimeout):
+		slog.Warn("drain timeout exceeded, closing the remaining connections")
+		grpcServer.Stop()
 	}
+}
 
-	grpcServer := grpc.NewServer(serverOption...)
+func runRESTServer(
+	ctx context.Context,
+	registry *prometheus.Registry,
+	tracerProvider *sdktrace.TracerProvider,
+	jwtManager *service.JWTManager,
+	tlsReloader *service.TLSReloader,
+	listener net.Listener,
+	grpcEndpoint string,
+	drainTimeout time.Duration,
+) error {
+	// the connection to the gRPC server stays open while the server drains
+	conn, err := grpc.Dial(grpcEndpoint, gatewayDialOptions(tracerProvider)...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
-	pb.RegisterAuthServiceServer(grpcServer, authServer)
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
-	reflection.Register(grpcServer)
+	handler, err := newRESTHandler(registry, tracerProvider, jwtManager, conn)
+	if err != nil {
+		return err
+	}
 
-	log.Printf("Start gRPC server at %s, with TLS = %t", listener.Addr().String(), enableTLS)
+	// the gRPC server is in another process: the event streams that it serves are ended here
+	handler, endEventStreams := gateway.EndEventStreams(handler)
 
-	return grpcServer.Serve(listener) 
+	slog.Info("start REST server", "address", listener.Addr().String(), "tls", tlsReloader != nil)
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(endEventStreams)
+	if tlsReloader != nil {
+		server.TLSConfig = tlsReloader.Config(&tls.Config{NextProtos: []string{"h2", "http/1.1"}})
+	}
+	return serveHTTP(ctx, server, tlsReloader != nil, listener, drainTimeout, nil, nil)
 }
 
-func runRESTServer(
-	authServer *service.AuthServer,
-	laptopServer *service.LaptopServer,
+// runServer serves the gRPC server, the REST gateway, the swagger UI and the metrics
+// on a single listener, calling ready once it serves. The gateway calls the gRPC server
+// on a loopback listener.
+func runServer(
+	ctx context.Context,
+	grpcServer *grpc.Server,
+	ready func(),
+	shutdown func(),
+	registry *prometheus.Registry,
+	tracerProvider *sdktrace.TracerProvider,
 	jwtManager *service.JWTManager,
-	enableTLS bool,
+	tlsReloader *service.TLSReloader,
 	listener net.Listener,
-	grpcEndpoint string,
+	drainTimeout time.Duration,
 ) error {
-	mux := runtime.NewServeMux()
-	dialOpts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	// the calls of the gateway are attributed to the addresses it forwards, as they come
+	// from the loopback interface
+	gatewayListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	go grpcServer.Serve(gatewayListener)
 
-	// in-process handler
-	// err := pb.RegisterAuthServiceHandlerServer(ctx, mux, authServer)
-	err := pb.RegisterAuthServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOpts)
+	conn, err := grpc.Dial(gatewayListener.Addr().String(), gatewayDialOptions(tracerProvider)...)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	// err = pb.RegisterLaptopServiceHandlerServer(ctx, mux, laptopServer)
-	err = pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, dialOpts)
+	restHandler, err := newRESTHandler(registry, tracerProvider, jwtManager, conn)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Start REST server at %s, with TLS = %t", listener.Addr().String(), enableTLS)
-	if enableTLS {
-		return http.ServeTLS(listener, mux, serverCertFile, serverKeyFile)
+	mux := gateway.Multiplex(grpcServer, restHandler)
+	var handler http.Handler = mux
+	server := &http.Server{}
+	if tlsReloader != nil {
+		// the browsers using the REST API and the swagger UI have no client certificate
+		server.TLSConfig = tlsReloader.Config(&tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			NextProtos: []string{"h2", "http/1.1"},
+		})
+	} else {
+		handler = h2c.NewHandler(handler, &http2.Server{})
 	}
+	server.Handler = handler
+
+	slog.Info("start server", "address", listener.Addr().String(), "tls", tlsReloader != nil)
+	server.RegisterOnShutdown(shutdown)
+	// the gRPC requests of the h2c connections are hijacked from the HTTP server: they are
+	// drained by the multiplexer, which then stops the gRPC server
+	err = serveHTTP(ctx, server, tlsReloader != nil, listener, drainTimeout, ready, mux.Stop)
+	grpcServer.Stop()
+	return err
+}
 
-	return http.Serve(listener, mux)
+// gatewayDialOptions returns the options of the connection from the REST gateway to
+// the gRPC server.
+func gatewayDialOptions(tracerProvider *sdktrace.TracerProvider) []grpc.DialOption {
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	return append(dialOpts, gateway.TracingDialOptions(tracerProvider, otel.GetTextMapPropagator())...)
 }
 
-func seedUsers(userStore service.UserStore) error {
-	err := createUser(userStore, "admin1", "secret", "admin")
+// newRESTHandler returns the handler of the REST gateway calling the gRPC server on conn,
+// with the JWKS, health, metrics and swagger endpoints.
+func newRESTHandler(
+	registry *prometheus.Registry,
+	tracerProvider *sdktrace.TracerProvider,
+	jwtManager *service.JWTManager,
+	conn *grpc.ClientConn,
+) (http.Handler, error) {
+	ctx := context.Background()
+	muxOptions := append(gateway.WithRequestID(), gateway.WithServerSentEvents())
+	mux := runtime.NewServeMux(muxOptions...)
+
+	for _, register := range []func(context.Context, *runtime.ServeMux, *grpc.ClientConn) error{
+		pb.RegisterAuthServiceHandler,
+		pb.RegisterLaptopServiceHandler,
+		pb.RegisterWebhookServiceHandler,
+		pb.RegisterAPIKeyServiceHandler,
+		pb.RegisterAuditServiceHandler,
+	} {
+		err := register(ctx, mux, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mux.HandlePath(http.MethodGet, gateway.JWKSPath, gateway.JWKSHandler(jwtManager))
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = mux.HandlePath(http.MethodGet, gateway.HealthzPath, handlerFunc(gateway.HealthzHandler()))
+	if err != nil {
+		return nil, err
+	}
+
+	err = mux.HandlePath(http.MethodGet, gateway.ReadyzPath, handlerFunc(gateway.ReadyzHandler(healthpb.NewHealthClient(conn))))
+	if err != nil {
+		return nil, err
 	}
-	return createUser(userStore, "user1", "secret", "user")
-}
 
-func createUser(userStore service.UserStore, username, password, role string) error {
-	user, err := service.NewUser(username, password, role)
+	err = mux.HandlePath(http.MethodGet, gateway.MetricsPath, handlerFunc(gateway.MetricsHandler(registry)))
 	if err != nil {
+		return nil, err
+	}
+
+	swaggerHandler, err := gateway.SwaggerHandler(swagger.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/", mux)
+	httpMux.Handle(gateway.SwaggerPath, swaggerHandler)
+
+	handler := gateway.InstrumentHandler(registry, httpMux)
+	return gateway.TraceHandler(tracerProvider, otel.GetTextMapPropagator(), handler), nil
+}
+
+// serveHTTP serves the HTTP server on the listener, calling ready once it serves, if set,
+// until the context is done, then shuts it down gracefully, closing the connections still
+// open after the drain timeout. The requests that the shutdown does not wait for are
+// drained by drain, if set, within the same timeout.
+func serveHTTP(
+	ctx context.Context,
+	server *http.Server,
+	enableTLS bool,
+	listener net.Listener,
+	drainTimeout time.Duration,
+	ready func(),
+	drain func(context.Context) error,
+) error {
+	errs := make(chan error, 1)
+	go func() {
+		if enableTLS {
+			errs <- server.ServeTLS(listener, "", "")
+			return
+		}
+		errs <- server.Serve(listener)
+	}()
+	if ready != nil {
+		ready()
+	}
+
+	select {
+	case err := <-errs:
 		return err
+	case <-ctx.Done():
 	}
 
-	return userStore.Save(user)
+	slog.Info("shutting down HTTP server", "drain_timeout", drainTimeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelShutdown()
+
+	err := server.Shutdown(shutdownCtx)
+	if drain != nil {
+		drainErr := drain(shutdownCtx)
+		if err == nil {
+			err = drainErr
+		}
+	}
+	if err != nil {
+		slog.Warn("drain timeout exceeded, closing the remaining connections")
+		return server.Close()
+	}
+	return nil
+}
+
+// handlerFunc adapts an http.Handler to the handlers of the paths of a runtime.ServeMux.
+func handlerFunc(handler http.Handler) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		handler.ServeHTTP(w, r)
+	}
 }
 
-func accessibleRoles() map[string][]string {
-	laptopServerPath := "/LaptopService/"
+// runMetricsServer serves the metrics of registry on /metrics at port.
+func runMetricsServer(registry *prometheus.Registry, port int) {
+	mux := http.NewServeMux()
+	mux.Handle(gateway.MetricsPath, gateway.MetricsHandler(registry))
 
-	return map[string][]string{
-		laptopServerPath + "CreateLaptop": {"admin"},
-		laptopServerPath + "RateLaptop":   {"admin", "user"},
-		laptopServerPath + "UploadImage":  {"admin"},
+	address := fmt.Sprintf(":%d", port)
+	slog.Info("start metrics server", "address", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Fatalf("cannot start metrics server: %v", err)
 	}
 }
 
-func readInConfig(path string) (string, string, error) {
-	privateKey, err := os.ReadFile(path + "private_key.pem")
+// loadConfig returns the configuration read from the file, if any, overridden by the
+// environment and by the flags set on the command line, and validated.
+func loadConfig(file string) (*config.Config, error) {
+	cfg, err := config.Load(file, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.ApplyFlags(flag.CommandLine)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// seedUsers creates the users of the configuration, whose roles must be roles of the
+// policy, and whose passwords must satisfy the password policy and are hashed with
+// passwordHasher like those of the registered users.
+func seedUsers(
+	userStore service.UserStore,
+	users []config.UserConfig,
+	policy *service.Policy,
+	passwordPolicy *service.PasswordPolicy,
+	passwordHasher service.PasswordHasher,
+) error {
+	for _, userConfig := range users {
+		if !policy.HasRole(userConfig.Role) {
+			return fmt.Errorf("user %s: unknown role %q", userConfig.Username, userConfig.Role)
+		}
+
+		err := passwordPolicy.Validate(userConfig.Username, string(userConfig.Password))
+		if err != nil {
+			return fmt.Errorf("user %s: %w", userConfig.Username, err)
+		}
+
+		user, err := service.NewUserWithHasher(
+			userConfig.Username,
+			string(userConfig.Password),
+			userConfig.Role,
+			passwordHasher,
+		)
+		if err != nil {
+			return err
+		}
+		user.Tenant = userConfig.Tenant
+
+		err = userStore.Save(user)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	publicKey, err := os.ReadFile(path + "public_key.pem")
+// readKeys returns the PEM private and public keys signing the tokens, read from the
+// files unless they are set in the configuration, and the PEM verification keys.
+func readKeys(jwtConfig config.JWTConfig) (string, string, []string, error) {
+	privateKey := string(jwtConfig.PrivateKey)
+	if privateKey == "" {
+		data, err := os.ReadFile(jwtConfig.PrivateKeyFile)
+		if err != nil {
+			return "", "", nil, err
+		}
+		privateKey = string(data)
+	}
+
+	publicKey := jwtConfig.PublicKey
+	if publicKey == "" {
+		data, err := os.ReadFile(jwtConfig.PublicKeyFile)
+		if err != nil {
+			return "", "", nil, err
+		}
+		publicKey = string(data)
+	}
+
+	verificationKeys := make([]string, 0, len(jwtConfig.VerificationKeyFiles))
+	for _, file := range jwtConfig.VerificationKeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", nil, err
+		}
+		verificationKeys = append(verificationKeys, string(data))
+	}
+
+	return privateKey, publicKey, verificationKeys, nil
+}
+
+// reloadKeys replaces the key set of the JWT manager with the keys read again.
+func reloadKeys(jwtManager *service.JWTManager, jwtConfig config.JWTConfig) error {
+	privateKey, publicKey, verificationKeys, err := readKeys(jwtConfig)
 	if err != nil {
-		return "", "", err
+		return err
 	}
 
-	return string(privateKey), string(publicKey), nil
+	return jwtManager.ReplaceKeys(privateKey, publicKey, verificationKeys...)
 }
 
-func loadTLSCredentials() (credentials.TransportCredentials, error) {
-	// load certificate of the CA who signed client's certificate.
-	pemClientCA, err := os.ReadFile(clientCACertFile)
+// loadPolicy returns the authorization policy of the file, with the roles of the
+// configuration and the rules of the proto files.
+func loadPolicy(policyConfig config.PolicyConfig) (*service.Policy, error) {
+	policy, err := service.LoadPolicy(policyConfig.File)
 	if err != nil {
 		return nil, err
 	}
 
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(pemClientCA) {
-		return nil, fmt.Errorf("failed to add client CA's certificate")
+	for role, permissions := range policyConfig.Roles {
+		err = policy.SetRole(role, permissions)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// load server certificate and private key
-	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	err = policy.AddMethods(service.MethodPolicies(protoregistry.GlobalFiles))
 	if err != nil {
 		return nil, err
 	}
+	return policy, nil
+}
+
+// watchReloads calls reload on SIGHUP, and when one of the files changes if interval
+// is not zero, until the context is done.
+func watchReloads(ctx context.Context, interval time.Duration, files []string, reload func()) {
+	if interval > 0 {
+		service.WatchFiles(ctx, interval, files, reload)
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+
+		for {
+			select {
+			case <-hangup:
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
 
-	// create the credentials and return it
-	config := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    certPool,
+// reloadedFiles returns the files of the TLS certificates, the JWT keys and the policy.
+func reloadedFiles(cfg *config.Config, tlsReloader *service.TLSReloader) []string {
+	var files []string
+	if tlsReloader != nil {
+		files = append(files, tlsReloader.Files()...)
+	}
+	if cfg.JWT.PrivateKey == "" {
+		files = append(files, cfg.JWT.PrivateKeyFile)
+	}
+	if cfg.JWT.PublicKey == "" {
+		files = append(files, cfg.JWT.PublicKeyFile)
+	}
+	files = append(files, cfg.JWT.VerificationKeyFiles...)
+	return append(files, cfg.Policy.File)
+}
+
+// logReload logs the result of the reload of some files. The server keeps using the
+// previous files when they cannot be reloaded.
+func logReload(files string, err error) {
+	if err != nil {
+		slog.Error("cannot reload files, keeping the previous ones", "files", files, "error", err)
+		return
+	}
+	slog.Info("reloaded files", "files", files)
+}
+
+// newLogger returns a logger writing to stderr at the given level, in text or JSON.
+func newLogger(level string, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	err := logLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// newTracerProvider returns a tracer provider sampling ratio of the new traces, and
+// exporting them to exporter: none, stdout or otlp at the endpoint.
+func newTracerProvider(exporter string, endpoint string, serviceName string, ratio float64) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	}
+
+	switch exporter {
+	case "none":
+	case "stdout":
+		spanExporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("cannot create stdout trace exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(spanExporter))
+	case "otlp":
+		spanExporter, err := otlptracegrpc.New(
+			context.Background(),
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create OTLP trace exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(spanExporter))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", exporter)
 	}
 
-	return credentials.NewTLS(config), nil
+	return sdktrace.NewTracerProvider(options...), nil
 }
@@ -0,0 +1,443 @@
+// Package config loads the configuration of the server from a YAML file, the
+// environment and the command-line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	TLS       TLSConfig       `yaml:"tls"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Stores    StoresConfig    `yaml:"stores"`
+	Images    ImagesConfig    `yaml:"images"`
+	Ratings   RatingsConfig   `yaml:"ratings"`
+	Passwords PasswordsConfig `yaml:"passwords"`
+	Policy    PolicyConfig    `yaml:"policy"`
+	// Tenants are the tenants besides the DefaultTenant, each with its own laptop catalog.
+	Tenants []string `yaml:"tenants"`
+	// Users are the users created when the server starts.
+	Users      []UserConfig     `yaml:"users"`
+	RateLimits RateLimitsConfig `yaml:"rate_limits"`
+	Quotas     QuotasConfig     `yaml:"quotas"`
+	Audit      AuditConfig      `yaml:"audit"`
+	Log        LogConfig        `yaml:"log"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Health     HealthConfig     `yaml:"health"`
+}
+
+// ServerConfig is the configuration of the listeners.
+type ServerConfig struct {
+	Port int `yaml:"port"`
+	// Type is grpc, rest, or all to serve gRPC and REST on the same port.
+	Type string `yaml:"type"`
+	// GRPCEndpoint is the address of the gRPC server called by the REST server.
+	GRPCEndpoint string `yaml:"grpc_endpoint"`
+	// MetricsPort is the port exposing the metrics of the gRPC server, 0 to disable it.
+	MetricsPort int `yaml:"metrics_port"`
+	// DrainTimeout is the time given to the in-flight RPCs and requests to finish on shutdown.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// ReloadInterval is the interval of the checks of the TLS, JWT key and policy files,
+	// which are reloaded when they change. 0 disables the checks: the files are only
+	// reloaded on SIGHUP.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// TLSConfig is the configuration of the TLS listeners.
+type TLSConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// CertIdentitiesFile maps client certificates to users and roles, to authenticate
+	// them without tokens.
+	CertIdentitiesFile string `yaml:"cert_identities_file"`
+}
+
+// JWTConfig is the configuration of the tokens.
+type JWTConfig struct {
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// PrivateKey is the PEM private key signing the tokens, read instead of PrivateKeyFile.
+	PrivateKey    Secret `yaml:"private_key"`
+	PublicKeyFile string `yaml:"public_key_file"`
+	// PublicKey is the PEM public key of the private key, read instead of PublicKeyFile.
+	PublicKey string `yaml:"public_key"`
+	// VerificationKeyFiles are the public keys of previous signing keys, still accepted
+	// for verification.
+	VerificationKeyFiles []string      `yaml:"verification_key_files"`
+	Issuer               string        `yaml:"issuer"`
+	Audience             string        `yaml:"audience"`
+	Leeway               time.Duration `yaml:"leeway"`
+	TokenDuration        time.Duration `yaml:"token_duration"`
+	RefreshDuration      time.Duration `yaml:"refresh_duration"`
+}
+
+// StoresConfig selects the backends of the stores.
+type StoresConfig struct {
+	// Laptops is the backend of the laptop stores: memory.
+	Laptops string `yaml:"laptops"`
+	// Images is the backend of the image stores: disk.
+	Images string `yaml:"images"`
+	// ImageFolder is the folder of the disk image stores, with a subfolder per tenant.
+	ImageFolder string `yaml:"image_folder"`
+	// Ratings is the backend of the rating stores: memory.
+	Ratings string `yaml:"ratings"`
+}
+
+// ImagesConfig is the configuration of the laptop images.
+type ImagesConfig struct {
+	// MaxSize is the maximum size of the uploaded images, in bytes.
+	MaxSize int `yaml:"max_size"`
+}
+
+// RatingsConfig is the configuration of the laptop ratings.
+type RatingsConfig struct {
+	// Scale is the scale of the allowed scores, as min:max[:step].
+	Scale string `yaml:"scale"`
+	// PriorWeight is the number of virtual ratings in the Bayesian average score.
+	PriorWeight float64 `yaml:"prior_weight"`
+	// PriorMean is the score of the virtual ratings, the middle of the scale if nil.
+	PriorMean *float64 `yaml:"prior_mean"`
+	// HalfLife is the age at which a rating counts half in the decayed score.
+	HalfLife time.Duration `yaml:"half_life"`
+}
+
+// PasswordsConfig is the configuration of the user passwords.
+type PasswordsConfig struct {
+	// Hash is the password hashing algorithm: bcrypt or argon2id.
+	Hash            string `yaml:"hash"`
+	BcryptCost      int    `yaml:"bcrypt_cost"`
+	MinLength       int    `yaml:"min_length"`
+	RequiredClasses int    `yaml:"required_classes"`
+	// BlocklistFile lists the rejected passwords, one per line. Empty disables it.
+	BlocklistFile string `yaml:"blocklist_file"`
+}
+
+// PolicyConfig is the configuration of the authorization policy.
+type PolicyConfig struct {
+	File string `yaml:"file"`
+	// Roles replace the permissions of the roles of the policy file.
+	Roles map[string][]service.Permission `yaml:"roles"`
+}
+
+// UserConfig is a user created when the server starts.
+type UserConfig struct {
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+	Role     string `yaml:"role"`
+	// Tenant is the tenant of the user, the DefaultTenant if empty.
+	Tenant string `yaml:"tenant"`
+}
+
+// RateLimitsConfig is the configuration of the rate limits of each caller, identified
+// by its API key, its username, or else its IP address.
+type RateLimitsConfig struct {
+	// Default limits the calls to all the methods without their own limit together.
+	Default RateLimitConfig `yaml:"default"`
+	// Methods are the limits of the methods, by full method name such as
+	// /LaptopService/CreateLaptop, or of all the methods of a service, such as /LaptopService/*.
+	Methods map[string]RateLimitConfig `yaml:"methods"`
+}
+
+// RateLimitConfig limits the calls of each caller. The zero fields are not limited.
+type RateLimitConfig struct {
+	// Rate is the number of calls per second allowed on average, in bursts of Burst calls.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+	// MaxConcurrent is the number of calls allowed in progress at once, such as open streams.
+	MaxConcurrent int `yaml:"max_concurrent"`
+}
+
+// QuotasConfig is the configuration of the quotas of each tenant. The zero quotas are not limited.
+type QuotasConfig struct {
+	// MaxLaptops is the number of laptops in the catalog of a tenant.
+	MaxLaptops int `yaml:"max_laptops"`
+	// DailyUploadBytes is the size of the images uploaded by a tenant during a UTC day.
+	DailyUploadBytes int `yaml:"daily_upload_bytes"`
+}
+
+// AuditConfig is the configuration of the audit log.
+type AuditConfig struct {
+	// LogFile is the file the audit log is appended to, one JSON entry per line.
+	LogFile string `yaml:"log_file"`
+}
+
+// LogConfig is the configuration of the logs.
+type LogConfig struct {
+	// Level is the minimum level of the logs: debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is the format of the logs: text or json.
+	Format string `yaml:"format"`
+}
+
+// TracingConfig is the configuration of the traces.
+type TracingConfig struct {
+	// Exporter is the exporter of the traces: none, stdout or otlp.
+	Exporter     string `yaml:"exporter"`
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// SampleRatio is the ratio of the new traces that are sampled.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// HealthConfig is the configuration of the health checks.
+type HealthConfig struct {
+	// Interval is the interval of the health checks of the stores.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Secret is a string redacted when the configuration is printed.
+type Secret string
+
+// Redacted replaces the secrets when the configuration is printed.
+const Redacted = "REDACTED"
+
+// String returns Redacted, or an empty string if the secret is empty.
+func (secret Secret) String() string {
+	if secret == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// MarshalYAML encodes the secret as Redacted.
+func (secret Secret) MarshalYAML() (interface{}, error) {
+	return secret.String(), nil
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Type:         "grpc",
+			DrainTimeout: 30 * time.Second,
+		},
+		TLS: TLSConfig{
+			CertFile:     "cert/server-cert.pem",
+			KeyFile:      "cert/server-key.pem",
+			ClientCAFile: "cert/ca-cert.pem",
+		},
+		JWT: JWTConfig{
+			PrivateKeyFile:  "keys/private_key.pem",
+			PublicKeyFile:   "keys/public_key.pem",
+			Issuer:          service.DefaultJWTIssuer,
+			Audience:        service.DefaultJWTAudience,
+			Leeway:          service.DefaultJWTLeeway,
+			TokenDuration:   15 * time.Minute,
+			RefreshDuration: 7 * 24 * time.Hour,
+		},
+		Stores: StoresConfig{
+			Laptops:     "memory",
+			Images:      "disk",
+			ImageFolder: "img",
+			Ratings:     "memory",
+		},
+		Images: ImagesConfig{
+			MaxSize: service.DefaultMaxImageSize,
+		},
+		Ratings: RatingsConfig{
+			Scale:       service.DefaultRatingScale.String(),
+			PriorWeight: 5,
+			HalfLife:    service.DefaultRatingHalfLife,
+		},
+		Passwords: PasswordsConfig{
+			Hash:            "bcrypt",
+			BcryptCost:      bcrypt.DefaultCost,
+			MinLength:       service.DefaultPasswordPolicy.MinLength,
+			RequiredClasses: service.DefaultPasswordPolicy.RequiredClasses,
+			BlocklistFile:   "password-blocklist.txt",
+		},
+		Policy: PolicyConfig{
+			File: "policy.json",
+		},
+		Users: []UserConfig{
+			{Username: "admin1", Password: "pcbook-secret", Role: service.RoleAdmin},
+			{Username: "user1", Password: "pcbook-secret", Role: service.RoleUser},
+		},
+		RateLimits: RateLimitsConfig{
+			Methods: map[string]RateLimitConfig{
+				"/LaptopService/CreateLaptop": {Rate: 10, Burst: 20},
+				"/LaptopService/UploadImage":  {Rate: 1, Burst: 5, MaxConcurrent: 2},
+				"/LaptopService/RateLaptop":   {Rate: 1, Burst: 5, MaxConcurrent: 5},
+			},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4317",
+			SampleRatio:  1,
+		},
+		Health: HealthConfig{
+			Interval: 10 * time.Second,
+		},
+	}
+}
+
+// Load returns the default configuration, overridden by the YAML file at path if it
+// is not empty, then by the environment variables found with lookupEnv.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %w", err)
+		}
+
+		err = cfg.parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse config file %s: %w", path, err)
+		}
+	}
+
+	err := cfg.applyEnv(lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// parse overrides the configuration with the fields set in the YAML data.
+// Unknown fields are rejected, to catch the typos.
+func (cfg *Config) parse(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(cfg)
+	if errors.Is(err, io.EOF) {
+		// the file is empty
+		return nil
+	}
+	return err
+}
+
+// Validate checks the configuration, and returns all the problems found.
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(cfg.Server.Port), "server.port: invalid port %d", cfg.Server.Port)
+	check(cfg.Server.MetricsPort == 0 || validPort(cfg.Server.MetricsPort), "server.metrics_port: invalid port %d", cfg.Server.MetricsPort)
+	check(oneOf(cfg.Server.Type, "grpc", "rest", "all"), "server.type: unknown server type %q", cfg.Server.Type)
+	check(cfg.Server.Type != "rest" || cfg.Server.GRPCEndpoint != "", "server.grpc_endpoint: required by the rest server")
+	check(cfg.Server.DrainTimeout >= 0, "server.drain_timeout: must not be negative")
+	check(cfg.Server.ReloadInterval >= 0, "server.reload_interval: must not be negative")
+
+	if cfg.TLS.Enabled {
+		check(cfg.TLS.CertFile != "", "tls.cert_file: required with TLS")
+		check(cfg.TLS.KeyFile != "", "tls.key_file: required with TLS")
+		check(cfg.TLS.ClientCAFile != "", "tls.client_ca_file: required with TLS")
+	} else {
+		check(cfg.TLS.CertIdentitiesFile == "", "tls.cert_identities_file: requires TLS")
+	}
+
+	check(cfg.JWT.PrivateKeyFile != "" || cfg.JWT.PrivateKey != "", "jwt.private_key_file: required without jwt.private_key")
+	check(cfg.JWT.PublicKeyFile != "" || cfg.JWT.PublicKey != "", "jwt.public_key_file: required without jwt.public_key")
+	check(cfg.JWT.Issuer != "", "jwt.issuer: required")
+	check(cfg.JWT.Audience != "", "jwt.audience: required")
+	check(cfg.JWT.Leeway >= 0, "jwt.leeway: must not be negative")
+	check(cfg.JWT.TokenDuration > 0, "jwt.token_duration: must be positive")
+	check(cfg.JWT.RefreshDuration > 0, "jwt.refresh_duration: must be positive")
+
+	check(cfg.Stores.Laptops == "memory", "stores.laptops: unknown backend %q", cfg.Stores.Laptops)
+	check(cfg.Stores.Images == "disk", "stores.images: unknown backend %q", cfg.Stores.Images)
+	check(cfg.Stores.ImageFolder != "", "stores.image_folder: required by the disk image stores")
+	check(cfg.Stores.Ratings == "memory", "stores.ratings: unknown backend %q", cfg.Stores.Ratings)
+
+	check(cfg.Images.MaxSize > 0, "images.max_size: must be positive")
+
+	_, err := service.ParseRatingScale(cfg.Ratings.Scale)
+	check(err == nil, "ratings.scale: %v", err)
+	check(cfg.Ratings.PriorWeight >= 0, "ratings.prior_weight: must not be negative")
+	check(cfg.Ratings.HalfLife >= 0, "ratings.half_life: must not be negative")
+
+	check(oneOf(cfg.Passwords.Hash, "bcrypt", "argon2id"), "passwords.hash: unknown password hashing algorithm %q", cfg.Passwords.Hash)
+	check(cfg.Passwords.BcryptCost >= bcrypt.MinCost && cfg.Passwords.BcryptCost <= bcrypt.MaxCost,
+		"passwords.bcrypt_cost: must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	check(cfg.Passwords.MinLength >= 0, "passwords.min_length: must not be negative")
+	check(cfg.Passwords.RequiredClasses >= 0 && cfg.Passwords.RequiredClasses <= 4, "passwords.required_classes: must be between 0 and 4")
+
+	check(cfg.Policy.File != "", "policy.file: required")
+
+	tenants := make(map[string]bool, len(cfg.Tenants))
+	for _, tenant := range cfg.Tenants {
+		err := service.ValidateTenant(tenant)
+		check(err == nil, "tenants: %v", err)
+		tenants[tenant] = true
+	}
+
+	usernames := make(map[string]bool, len(cfg.Users))
+	for i, user := range cfg.Users {
+		check(user.Username != "", "users[%d].username: required", i)
+		check(!usernames[user.Username], "users[%d].username: duplicate user %q", i, user.Username)
+		check(user.Password != "", "users[%d].password: required", i)
+		check(user.Role != "", "users[%d].role: required", i)
+		check(user.Tenant == "" || user.Tenant == service.DefaultTenant || tenants[user.Tenant], "users[%d].tenant: unknown tenant %q", i, user.Tenant)
+		usernames[user.Username] = true
+	}
+
+	err = service.RateLimit(cfg.RateLimits.Default).Validate()
+	check(err == nil, "rate_limits.default: %v", err)
+	for method, limit := range cfg.RateLimits.Methods {
+		err := service.ValidateMethodPattern(method)
+		check(err == nil, "rate_limits.methods: %v", err)
+		err = service.RateLimit(limit).Validate()
+		check(err == nil, "rate_limits.methods[%s]: %v", method, err)
+	}
+
+	check(cfg.Quotas.MaxLaptops >= 0, "quotas.max_laptops: must not be negative")
+	check(cfg.Quotas.DailyUploadBytes >= 0, "quotas.daily_upload_bytes: must not be negative")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "log.level: invalid log level %q", cfg.Log.Level)
+	check(oneOf(cfg.Log.Format, "text", "json"), "log.format: unknown log format %q", cfg.Log.Format)
+
+	check(oneOf(cfg.Tracing.Exporter, "none", "stdout", "otlp"), "tracing.exporter: unknown trace exporter %q", cfg.Tracing.Exporter)
+	check(cfg.Tracing.Exporter != "otlp" || cfg.Tracing.OTLPEndpoint != "", "tracing.otlp_endpoint: required by the otlp exporter")
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio: must be between 0 and 1")
+
+	check(cfg.Health.Interval > 0, "health.interval: must be positive")
+
+	return errors.Join(errs...)
+}
+
+// String returns the configuration as YAML, with the secrets redacted.
+func (cfg *Config) String() string {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Sprintf("cannot print config: %v", err)
+	}
+	return string(data)
+}
+
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
+func oneOf(value string, values ...string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
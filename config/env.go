@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables overriding the configuration.
+// The variable of a field is named after its YAML path, such as PCBOOK_SERVER_PORT
+// for server.port or PCBOOK_JWT_TOKEN_DURATION for jwt.token_duration. Lists are
+// comma-separated. The users, the roles of the policy and the rate limits of the
+// methods cannot be set this way.
+const EnvPrefix = "PCBOOK"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides the configuration with the environment variables found with lookupEnv.
+func (cfg *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookupEnv)
+}
+
+func applyEnv(value reflect.Value, name string, lookupEnv func(string) (string, bool)) error {
+	if value.Kind() == reflect.Struct {
+		for i := 0; i < value.NumField(); i++ {
+			tag, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+
+			err := applyEnv(value.Field(i), name+"_"+strings.ToUpper(tag), lookupEnv)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	env, ok := lookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	err := setValue(value, env)
+	if err != nil {
+		return fmt.Errorf("invalid environment variable %s: %w", name, err)
+	}
+	return nil
+}
+
+// setValue parses s into the value of a configuration field.
+func setValue(value reflect.Value, s string) error {
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Pointer:
+		elem := reflect.New(value.Type().Elem())
+		err := setValue(elem.Elem(), s)
+		if err != nil {
+			return err
+		}
+		value.Set(elem)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+
+		var items []string
+		if s != "" {
+			items = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i, item := range items {
+			slice.Index(i).SetString(strings.TrimSpace(item))
+		}
+		value.Set(slice)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// RegisterFlags defines the command-line flags of the configuration fields, with
+// their current values as defaults.
+func (cfg *Config) RegisterFlags(flags *flag.FlagSet) {
+	flags.IntVar(&cfg.Server.Port, "port", cfg.Server.Port, "server port value")
+	flags.StringVar(&cfg.Server.Type, "srv-type", cfg.Server.Type, "type of server (grpc/rest/all), all serving gRPC and REST on the same port")
+	flags.StringVar(&cfg.Server.GRPCEndpoint, "grpc-endpoint", cfg.Server.GRPCEndpoint, "gRPC endpoint")
+	flags.IntVar(&cfg.Server.MetricsPort, "metrics-port", cfg.Server.MetricsPort, "port of the HTTP server exposing the metrics of the gRPC server on /metrics (0 to disable)")
+	flags.DurationVar(&cfg.Server.DrainTimeout, "drain-timeout", cfg.Server.DrainTimeout, "time given to the in-flight RPCs and requests to finish on shutdown")
+	flags.DurationVar(&cfg.Server.ReloadInterval, "reload-interval", cfg.Server.ReloadInterval, "interval of the checks of the TLS, JWT key and policy files, reloaded when they change (0 to only reload them on SIGHUP)")
+
+	flags.BoolVar(&cfg.TLS.Enabled, "tls", cfg.TLS.Enabled, "enable SSL/TLS")
+	flags.StringVar(&cfg.TLS.CertIdentitiesFile, "cert-identities", cfg.TLS.CertIdentitiesFile, "file mapping client certificates to users and roles, to authenticate them without tokens (requires -tls)")
+
+	flags.StringVar(&cfg.JWT.Issuer, "jwt-issuer", cfg.JWT.Issuer, "issuer claim of the tokens")
+	flags.StringVar(&cfg.JWT.Audience, "jwt-audience", cfg.JWT.Audience, "audience claim of the tokens")
+	flags.DurationVar(&cfg.JWT.Leeway, "jwt-leeway", cfg.JWT.Leeway, "clock skew tolerated when verifying tokens")
+	flags.Var((*stringList)(&cfg.JWT.VerificationKeyFiles), "jwt-verification-keys", "comma-separated public key files of previous signing keys, still accepted for verification")
+
+	flags.IntVar(&cfg.Images.MaxSize, "max-image-size", cfg.Images.MaxSize, "maximum size of the uploaded images, in bytes")
+
+	flags.StringVar(&cfg.Ratings.Scale, "rating-scale", cfg.Ratings.Scale, "allowed laptop scores as min:max[:step]")
+	flags.Float64Var(&cfg.Ratings.PriorWeight, "rating-prior-weight", cfg.Ratings.PriorWeight, "number of virtual ratings in the Bayesian average score")
+	flags.Var(&optionalFloat{&cfg.Ratings.PriorMean}, "rating-prior-mean", "score of the virtual ratings (default middle of the rating scale)")
+	flags.DurationVar(&cfg.Ratings.HalfLife, "rating-half-life", cfg.Ratings.HalfLife, "age at which a rating counts half in the decayed score")
+
+	flags.StringVar(&cfg.Passwords.Hash, "password-hash", cfg.Passwords.Hash, "password hashing algorithm (bcrypt/argon2id)")
+	flags.IntVar(&cfg.Passwords.BcryptCost, "bcrypt-cost", cfg.Passwords.BcryptCost, "cost of the bcrypt password hashes")
+	flags.IntVar(&cfg.Passwords.MinLength, "password-min-length", cfg.Passwords.MinLength, "minimum length of the passwords")
+	flags.IntVar(&cfg.Passwords.RequiredClasses, "password-classes", cfg.Passwords.RequiredClasses, "number of character classes required in the passwords")
+	flags.StringVar(&cfg.Passwords.BlocklistFile, "password-blocklist", cfg.Passwords.BlocklistFile, "file of rejected passwords, one per line (empty to disable)")
+
+	flags.StringVar(&cfg.Policy.File, "policy", cfg.Policy.File, "authorization policy file")
+	flags.Var((*stringList)(&cfg.Tenants), "tenants", "comma-separated tenants besides the default one, each with its own laptop catalog")
+	flags.IntVar(&cfg.Quotas.MaxLaptops, "max-laptops", cfg.Quotas.MaxLaptops, "maximum number of laptops in the catalog of a tenant (0 for no limit)")
+	flags.IntVar(&cfg.Quotas.DailyUploadBytes, "daily-upload-bytes", cfg.Quotas.DailyUploadBytes, "maximum size of the images uploaded by a tenant per UTC day, in bytes (0 for no limit)")
+
+	flags.StringVar(&cfg.Audit.LogFile, "audit-log", cfg.Audit.LogFile, "file to append the audit log to, one JSON entry per line")
+
+	flags.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "minimum level of the logs (debug/info/warn/error)")
+	flags.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "format of the logs (text/json)")
+
+	flags.StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, "exporter of the traces (none/stdout/otlp)")
+	flags.StringVar(&cfg.Tracing.OTLPEndpoint, "otlp-endpoint", cfg.Tracing.OTLPEndpoint, "address of the OTLP gRPC collector receiving the traces")
+	flags.Float64Var(&cfg.Tracing.SampleRatio, "trace-sample-ratio", cfg.Tracing.SampleRatio, "ratio of the new traces that are sampled")
+
+	flags.DurationVar(&cfg.Health.Interval, "health-interval", cfg.Health.Interval, "interval of the health checks of the stores")
+}
+
+// ApplyFlags overrides the configuration with the flags set on the command line,
+// parsed into flags, so that they take precedence over the file and the environment.
+func (cfg *Config) ApplyFlags(flags *flag.FlagSet) error {
+	fields := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	cfg.RegisterFlags(fields)
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err != nil || fields.Lookup(f.Name) == nil {
+			return
+		}
+		err = fields.Set(f.Name, f.Value.String())
+	})
+	return err
+}
+
+// stringList is a flag.Value of comma-separated strings.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(s string) error {
+	*list = nil
+	if s != "" {
+		*list = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// optionalFloat is a flag.Value of a float that is nil until it is set.
+type optionalFloat struct {
+	value **float64
+}
+
+func (f *optionalFloat) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(**f.value, 'g', -1, 64)
+}
+
+func (f *optionalFloat) Set(s string) error {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*f.value = &value
+	return nil
+}
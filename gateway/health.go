@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// HealthzPath is the path of the liveness endpoint of the REST gateway.
+	HealthzPath = "/healthz"
+	// ReadyzPath is the path of the readiness endpoint of the REST gateway.
+	ReadyzPath = "/readyz"
+)
+
+// readyzTimeout is the timeout of the health check of the gRPC server.
+const readyzTimeout = 2 * time.Second
+
+// HealthzHandler reports that the REST gateway is alive.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyzHandler reports that the REST gateway is ready when the gRPC server behind it
+// is serving, according to its health service.
+func ReadyzHandler(client healthpb.HealthClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+		defer cancel()
+
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot check gRPC server: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			http.Error(w, res.GetStatus().String(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
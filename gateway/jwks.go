@@ -0,0 +1,26 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// JWKSPath is the well-known path of the JSON web key set verifying the access tokens.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWKSHandler serves the JSON web key set of the JWT manager, so that other services
+// can verify the access tokens without sharing the key files.
+func JWKSHandler(jwtManager *service.JWTManager) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		err := json.NewEncoder(w).Encode(jwtManager.JWKS())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath is the path of the Prometheus metrics endpoint.
+const MetricsPath = "/metrics"
+
+// InstrumentHandler returns a handler recording the requests served by handler in
+// Prometheus metrics registered with registerer: their count by method and status code,
+// their duration, and the number of requests in flight.
+func InstrumentHandler(registerer prometheus.Registerer, handler http.Handler) http.Handler {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests served by the REST gateway, by method and status code.",
+	}, []string{"method", "code"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of the HTTP requests served by the REST gateway, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently served by the REST gateway.",
+	})
+	registerer.MustRegister(requests, duration, inFlight)
+
+	return promhttp.InstrumentHandlerInFlight(inFlight,
+		promhttp.InstrumentHandlerDuration(duration,
+			promhttp.InstrumentHandlerCounter(requests, handler),
+		),
+	)
+}
+
+// MetricsHandler returns the handler serving the metrics of gatherer.
+func MetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
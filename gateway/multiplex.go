@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// A Multiplexer serves the gRPC requests with a gRPC server, and the other requests with
+// an HTTP handler, so that both can share a port. Without TLS, it must be wrapped with h2c
+// to accept HTTP/2 requests.
+type Multiplexer struct {
+	grpcServer  *grpc.Server
+	httpHandler http.Handler
+
+	mutex    sync.Mutex
+	inFlight int
+	// idle is closed when the last request in progress ends, once Stop waits for it.
+	idle chan struct{}
+}
+
+// Multiplex returns a Multiplexer serving the gRPC requests with grpcServer and the
+// other requests with httpHandler.
+func Multiplex(grpcServer *grpc.Server, httpHandler http.Handler) *Multiplexer {
+	return &Multiplexer{
+		grpcServer:  grpcServer,
+		httpHandler: httpHandler,
+	}
+}
+
+// ServeHTTP serves the requests whose content type is application/grpc with the gRPC
+// server, and the other requests with the HTTP handler.
+func (mux *Multiplexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mux.mutex.Lock()
+	mux.inFlight++
+	mux.mutex.Unlock()
+
+	defer func() {
+		mux.mutex.Lock()
+		defer mux.mutex.Unlock()
+
+		mux.inFlight--
+		if mux.inFlight == 0 && mux.idle != nil {
+			close(mux.idle)
+			mux.idle = nil
+		}
+	}()
+
+	if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		mux.grpcServer.ServeHTTP(w, r)
+		return
+	}
+	mux.httpHandler.ServeHTTP(w, r)
+}
+
+// Stop waits for the requests in progress to end, then stops the gRPC server. If ctx is
+// done first, it stops the server at once, closing the remaining gRPC requests, and
+// returns the error of ctx.
+//
+// The gRPC server cannot be stopped with GracefulStop, which does not support the
+// requests served through ServeHTTP, and http.Server.Shutdown does not wait for the
+// requests of the h2c connections, which are hijacked.
+func (mux *Multiplexer) Stop(ctx context.Context) error {
+	defer mux.grpcServer.Stop()
+
+	mux.mutex.Lock()
+	if mux.inFlight == 0 {
+		mux.mutex.Unlock()
+		return nil
+	}
+	if mux.idle == nil {
+		mux.idle = make(chan struct{})
+	}
+	idle := mux.idle
+	mux.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,212 @@
+package gateway_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/gateway"
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/serializer"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/IkehAkinyemi/pcbook/swagger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func TestMultiplex(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := newTestJWTManager(t)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+	auditStore := service.NewInMemoryAuditStore(service.DefaultAuditRetention)
+	auditInterceptor := service.NewAuditInterceptor(service.AuditedMethods(protoregistry.GlobalFiles), auditStore)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auditInterceptor.Unary(), authInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(auditInterceptor.Stream(), authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// the gateway calls the gRPC server on a loopback listener, as the server does
+	gatewayListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(gatewayListener)
+
+	gatewayConn, err := grpc.Dial(gatewayListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { gatewayConn.Close() })
+
+	mux := runtime.NewServeMux()
+	err = pb.RegisterLaptopServiceHandler(context.Background(), mux, gatewayConn)
+	require.NoError(t, err)
+
+	swaggerHandler, err := gateway.SwaggerHandler(swagger.Files)
+	require.NoError(t, err)
+
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/", mux)
+	httpMux.Handle(gateway.SwaggerPath, swaggerHandler)
+
+	server := &http.Server{Handler: h2c.NewHandler(gateway.Multiplex(grpcServer, httpMux), &http2.Server{})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	token, err := jwtManager.GenerateToken(&service.User{Username: "admin1", Role: service.RoleAdmin})
+	require.NoError(t, err)
+
+	// gRPC
+	laptop := sample.NewLaptop()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+	res, err := pb.NewLaptopServiceClient(conn).CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+	require.Equal(t, laptop.Id, res.Id)
+
+	// REST
+	body, err := serializer.ProtobufToJSON(&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://"+address+"/v1/laptops/create", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", token)
+	// the address of the REST client is the last one forwarded by the gateway
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	httpRes, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	httpRes.Body.Close()
+	require.Equal(t, http.StatusOK, httpRes.StatusCode)
+
+	found, err := laptopStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+
+	// the calls through the gateway are attributed to the address of the REST client
+	entries, err := auditStore.Query(service.AuditFilter{Method: "/LaptopService/CreateLaptop", Tenant: service.AllTenants})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		require.Equal(t, "OK", entry.Code)
+		require.Equal(t, "127.0.0.1", entry.IP)
+	}
+
+	// swagger UI and specifications
+	page := getTestPage(t, "http://"+address+gateway.SwaggerPath)
+	require.Contains(t, page, "laptop_service.swagger.json")
+
+	spec := getTestPage(t, "http://"+address+gateway.SwaggerPath+"laptop_service.swagger.json")
+	require.Contains(t, spec, "/v1/laptops/create")
+}
+
+func TestMultiplexStop(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	mux := gateway.Multiplex(grpcServer, http.NotFoundHandler())
+	server := &http.Server{Handler: h2c.NewHandler(mux, &http2.Server{})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// the health watch stream never ends by itself
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := server.Shutdown(ctx)
+		if err == nil {
+			err = mux.Stop(ctx)
+		}
+		stopped <- err
+	}()
+
+	select {
+	case err := <-stopped:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop")
+	}
+
+	_, err = stream.Recv()
+	require.Error(t, err)
+}
+
+func getTestPage(t *testing.T, url string) string {
+	res, err := http.Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func newTestJWTManager(t *testing.T) *service.JWTManager {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	manager, err := service.NewJWTManager(string(privatePEM), string(publicPEM), 15*time.Minute, 24*time.Hour)
+	require.NoError(t, err)
+	return manager
+}
+
+func loadTestPolicy(t *testing.T) *service.Policy {
+	policy, err := service.LoadPolicy("../policy.json")
+	require.NoError(t, err)
+
+	err = policy.AddMethods(service.MethodPolicies(protoregistry.GlobalFiles))
+	require.NoError(t, err)
+	return policy
+}
@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"fmt"
+	"net/textproto"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// requestIDHeader is the HTTP header carrying the ID of a request, forwarded to the
+// gRPC server in the x-request-id metadata.
+const requestIDHeader = "X-Request-Id"
+
+// IncomingHeaderMatcher forwards the X-Request-Id header to the gRPC server, on top of
+// the headers forwarded by default.
+func IncomingHeaderMatcher(key string) (string, bool) {
+	if textproto.CanonicalMIMEHeaderKey(key) == requestIDHeader {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// OutgoingHeaderMatcher returns the x-request-id metadata of the gRPC server in the
+// X-Request-Id header, and the other metadata with the default Grpc-Metadata- prefix.
+func OutgoingHeaderMatcher(key string) (string, bool) {
+	if textproto.CanonicalMIMEHeaderKey(key) == requestIDHeader {
+		return requestIDHeader, true
+	}
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
+
+// WithRequestID forwards the request IDs between the HTTP clients and the gRPC server.
+func WithRequestID() []runtime.ServeMuxOption {
+	return []runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(IncomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(OutgoingHeaderMatcher),
+	}
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// MIMEEventStream is the content type of server-sent events.
+const MIMEEventStream = "text/event-stream"
+
+// SSEMarshaler writes each message of a server-streaming RPC as a server-sent event,
+// so that browsers can subscribe to the stream with an EventSource.
+type SSEMarshaler struct {
+	runtime.JSONPb
+}
+
+// ContentType always returns the content type of server-sent events.
+func (*SSEMarshaler) ContentType(_ interface{}) string {
+	return MIMEEventStream
+}
+
+// Marshal marshals v into JSON, with each line prefixed as a data field of the event.
+func (marshaler *SSEMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := marshaler.JSONPb.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var event bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteString("\n")
+	}
+	return event.Bytes(), nil
+}
+
+// Delimiter returns the blank line that ends an event.
+func (*SSEMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
+
+// WithServerSentEvents registers the SSEMarshaler for requests that accept text/event-stream.
+func WithServerSentEvents() runtime.ServeMuxOption {
+	return runtime.WithMarshalerOption(MIMEEventStream, &SSEMarshaler{})
+}
+
+// EndEventStreams returns a handler serving the requests that accept server-sent events
+// with a context canceled when the returned function is called. The event streams never
+// end by themselves, so it must be called when the server shuts down, before it is drained.
+func EndEventStreams(next http.Handler) (http.Handler, func()) {
+	shutdown, end := context.WithCancel(context.Background())
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), MIMEEventStream) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			stop := context.AfterFunc(shutdown, cancel)
+			defer stop()
+
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+	return handler, end
+}
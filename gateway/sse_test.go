@@ -0,0 +1,57 @@
+package gateway_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/gateway"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndEventStreams(t *testing.T) {
+	t.Parallel()
+
+	ended := make(chan string, 2)
+	handler, end := gateway.EndEventStreams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		ended <- r.Header.Get("Accept")
+	}))
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	open := func(accept string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+	open(gateway.MIMEEventStream)
+	open("application/json")
+
+	// only the event streams are ended
+	end()
+	select {
+	case accept := <-ended:
+		require.Equal(t, gateway.MIMEEventStream, accept)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the event stream did not end")
+	}
+
+	select {
+	case accept := <-ended:
+		require.Fail(t, "a request was ended", accept)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
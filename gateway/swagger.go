@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// SwaggerPath is the path of the swagger UI, and of the OpenAPI specifications it shows.
+const SwaggerPath = "/swagger/"
+
+// swaggerUIVersion is the version of the swagger-ui-dist assets loaded by the UI.
+const swaggerUIVersion = "5.9.0"
+
+var swaggerIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>PCBook API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-bundle.js"></script>
+  <script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-standalone-preset.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({
+      urls: [{{range .Specs}}{url: "{{$.Path}}{{.}}", name: "{{.}}"},{{end}}],
+      dom_id: "#swagger-ui",
+      presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+      layout: "StandaloneLayout",
+    });
+  </script>
+</body>
+</html>
+`))
+
+// SwaggerHandler serves the *.swagger.json specifications of files, and a swagger UI
+// showing the specifications of the services.
+func SwaggerHandler(files fs.FS) (http.Handler, error) {
+	specs, err := fs.Glob(files, "*_service.swagger.json")
+	if err != nil {
+		return nil, err
+	}
+
+	fileServer := http.StripPrefix(SwaggerPath, http.FileServer(http.FS(files)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, SwaggerPath) != "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		swaggerIndex.Execute(w, struct {
+			Version string
+			Path    string
+			Specs   []string
+		}{swaggerUIVersion, SwaggerPath, specs})
+	}), nil
+}
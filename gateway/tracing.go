@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TraceHandler returns a handler starting a span for every request served by handler,
+// as a child of the trace context propagated in the request headers.
+func TraceHandler(provider trace.TracerProvider, propagator propagation.TextMapPropagator, handler http.Handler) http.Handler {
+	tracer := provider.Tracer(service.TracerName)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("net.sock.peer.addr", r.RemoteAddr),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(otelcodes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// statusRecorder is an http.ResponseWriter recording the status code of the response.
+// It can be flushed, for the server-streaming RPCs.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// TracingDialOptions return the dial options of the connection from the gateway to the
+// gRPC server, starting a client span for every call and propagating its trace context
+// in the metadata.
+func TracingDialOptions(provider trace.TracerProvider, propagator propagation.TextMapPropagator) []grpc.DialOption {
+	tracer := provider.Tracer(service.TracerName)
+
+	unary := func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := startClientSpan(ctx, tracer, propagator, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endClientSpan(span, err)
+		return err
+	}
+
+	stream := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		// the span of a stream only covers its creation, since the gateway
+		// cannot tell when it reads the last message
+		ctx, span := startClientSpan(ctx, tracer, propagator, method)
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		endClientSpan(span, err)
+		return clientStream, err
+	}
+
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unary),
+		grpc.WithChainStreamInterceptor(stream),
+	}
+}
+
+func startClientSpan(
+	ctx context.Context,
+	tracer trace.Tracer,
+	propagator propagation.TextMapPropagator,
+	method string,
+) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(service.RPCAttributes(method)...),
+	)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, service.MetadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func endClientSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(status.Code(err))))
+	span.End()
+}
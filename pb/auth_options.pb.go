@@ -0,0 +1,193 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.12
+// source: auth_options.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// MethodAuth declares who can call an RPC, and whether its calls are audited.
+type MethodAuth struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// public methods can be called without authentication.
+	Public bool `protobuf:"varint,1,opt,name=public,proto3" json:"public,omitempty"`
+	// permissions are all required to call a method that is not public.
+	Permissions []string `protobuf:"bytes,2,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	// audit records every call of the method in the audit log.
+	Audit bool `protobuf:"varint,3,opt,name=audit,proto3" json:"audit,omitempty"`
+}
+
+func (x *MethodAuth) Reset() {
+	*x = MethodAuth{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_auth_options_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MethodAuth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MethodAuth) ProtoMessage() {}
+
+func (x *MethodAuth) ProtoReflect() protoreflect.Message {
+	mi := &file_auth_options_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MethodAuth.ProtoReflect.Descriptor instead.
+func (*MethodAuth) Descriptor() ([]byte, []int) {
+	return file_auth_options_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MethodAuth) GetPublic() bool {
+	if x != nil {
+		return x.Public
+	}
+	return false
+}
+
+func (x *MethodAuth) GetPermissions() []string {
+	if x != nil {
+		return x.Permissions
+	}
+	return nil
+}
+
+func (x *MethodAuth) GetAudit() bool {
+	if x != nil {
+		return x.Audit
+	}
+	return false
+}
+
+var file_auth_options_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*MethodAuth)(nil),
+		Field:         50000,
+		Name:          "auth",
+		Tag:           "bytes,50000,opt,name=auth",
+		Filename:      "auth_options.proto",
+	},
+}
+
+// Extension fields to descriptorpb.MethodOptions.
+var (
+	// optional MethodAuth auth = 50000;
+	E_Auth = &file_auth_options_proto_extTypes[0]
+)
+
+var File_auth_options_proto protoreflect.FileDescriptor
+
+var file_auth_options_proto_rawDesc = []byte{
+	0x0a, 0x12, 0x61, 0x75, 0x74, 0x68, 0x5f, 0x6f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x6f, 0x72,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x5c, 0x0a, 0x0a, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64,
+	0x41, 0x75, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x12, 0x20, 0x0a, 0x0b,
+	0x70, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x0b, 0x70, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x14,
+	0x0a, 0x05, 0x61, 0x75, 0x64, 0x69, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x61,
+	0x75, 0x64, 0x69, 0x74, 0x3a, 0x41, 0x0a, 0x04, 0x61, 0x75, 0x74, 0x68, 0x12, 0x1e, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d,
+	0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xd0, 0x86, 0x03,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x41, 0x75, 0x74,
+	0x68, 0x52, 0x04, 0x61, 0x75, 0x74, 0x68, 0x42, 0x05, 0x5a, 0x03, 0x2f, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_auth_options_proto_rawDescOnce sync.Once
+	file_auth_options_proto_rawDescData = file_auth_options_proto_rawDesc
+)
+
+func file_auth_options_proto_rawDescGZIP() []byte {
+	file_auth_options_proto_rawDescOnce.Do(func() {
+		file_auth_options_proto_rawDescData = protoimpl.X.CompressGZIP(file_auth_options_proto_rawDescData)
+	})
+	return file_auth_options_proto_rawDescData
+}
+
+var file_auth_options_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_auth_options_proto_goTypes = []interface{}{
+	(*MethodAuth)(nil),                 // 0: MethodAuth
+	(*descriptorpb.MethodOptions)(nil), // 1: google.protobuf.MethodOptions
+}
+var file_auth_options_proto_depIdxs = []int32{
+	1, // 0: auth:extendee -> google.protobuf.MethodOptions
+	0, // 1: auth:type_name -> MethodAuth
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	1, // [1:2] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_auth_options_proto_init() }
+func file_auth_options_proto_init() {
+	if File_auth_options_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_auth_options_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MethodAuth); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_auth_options_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_auth_options_proto_goTypes,
+		DependencyIndexes: file_auth_options_proto_depIdxs,
+		MessageInfos:      file_auth_options_proto_msgTypes,
+		ExtensionInfos:    file_auth_options_proto_extTypes,
+	}.Build()
+	File_auth_options_proto = out.File
+	file_auth_options_proto_rawDesc = nil
+	file_auth_options_proto_goTypes = nil
+	file_auth_options_proto_depIdxs = nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// APIKeyServer is the server to manage API keys.
+type APIKeyServer struct {
+	pb.UnimplementedAPIKeyServiceServer
+	apiKeyStore APIKeyStore
+	policy      atomic.Pointer[Policy]
+}
+
+// NewAPIKeyServer returns a new APIKeyServer, creating keys scoped to the roles
+// and permissions of the policy.
+func NewAPIKeyServer(apiKeyStore APIKeyStore, policy *Policy) *APIKeyServer {
+	server := &APIKeyServer{
+		apiKeyStore: apiKeyStore,
+	}
+	server.policy.Store(policy)
+	return server
+}
+
+// SetPolicy replaces the policy of the roles that the next keys can be scoped to.
+func (server *APIKeyServer) SetPolicy(policy *Policy) {
+	server.policy.Store(policy)
+}
+
+// CreateAPIKey creates an API key for the caller's tenant, and returns the key.
+// Only its hash is stored, so the key cannot be returned again.
+func (server *APIKeyServer) CreateAPIKey(
+	ctx context.Context,
+	req *pb.CreateAPIKeyRequest,
+) (*pb.CreateAPIKeyResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "API key name is empty")
+	}
+
+	if (req.GetRole() == "") == (len(req.GetPermissions()) == 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "API key must be scoped to either a role or permissions")
+	}
+
+	policy := server.policy.Load()
+	if req.GetRole() != "" && !policy.HasRole(req.GetRole()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role: %s", req.GetRole())
+	}
+
+	var permissions []Permission
+	for _, permission := range req.GetPermissions() {
+		err := validatePermission(Permission(permission))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		permissions = append(permissions, Permission(permission))
+	}
+
+	tenant := TenantFromContext(ctx)
+	if tenant == AllTenants {
+		return nil, status.Errorf(codes.InvalidArgument, "API key must belong to a single tenant")
+	}
+
+	// the key cannot be granted permissions that the caller does not have, such as
+	// tenant:admin for cross-tenant keys
+	granted := permissions
+	if req.GetRole() != "" {
+		granted = policy.Roles[req.GetRole()]
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || !policy.PrincipalHasPermissions(principal, granted) {
+		return nil, status.Errorf(codes.PermissionDenied, "no permission to grant the permissions of this API key")
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if req.GetExpiresAt() != nil {
+		expiresAt = req.GetExpiresAt().AsTime()
+		if !expiresAt.After(now) {
+			return nil, status.Errorf(codes.InvalidArgument, "API key expiry is in the past")
+		}
+	}
+
+	id, key, err := GenerateAPIKey()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate API key: %v", err)
+	}
+
+	apiKey := &APIKey{
+		ID:          id,
+		Name:        req.GetName(),
+		Role:        req.GetRole(),
+		Permissions: permissions,
+		Hash:        HashAPIKey(key),
+		Tenant:      tenant,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+
+	err = server.apiKeyStore.Save(apiKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save API key: %v", err)
+	}
+
+	res := &pb.CreateAPIKeyResponse{
+		ApiKey: toPBAPIKey(apiKey),
+		Key:    key,
+	}
+	return res, nil
+}
+
+// ListAPIKeys returns the API keys of the caller's tenant, without their secret part.
+func (server *APIKeyServer) ListAPIKeys(
+	ctx context.Context,
+	req *pb.ListAPIKeysRequest,
+) (*pb.ListAPIKeysResponse, error) {
+	apiKeys, err := server.apiKeyStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list API keys: %v", err)
+	}
+
+	res := &pb.ListAPIKeysResponse{}
+	for _, apiKey := range apiKeys {
+		if inTenant(ctx, apiKey.Tenant) {
+			res.ApiKeys = append(res.ApiKeys, toPBAPIKey(apiKey))
+		}
+	}
+	return res, nil
+}
+
+// RevokeAPIKey deletes an API key of the caller's tenant, which cannot be used anymore.
+func (server *APIKeyServer) RevokeAPIKey(
+	ctx context.Context,
+	req *pb.RevokeAPIKeyRequest,
+) (*pb.RevokeAPIKeyResponse, error) {
+	apiKey, err := server.apiKeyStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+	if apiKey == nil || !inTenant(ctx, apiKey.Tenant) {
+		return nil, status.Errorf(codes.NotFound, "API key %s is not found", req.GetId())
+	}
+
+	err = server.apiKeyStore.Delete(req.GetId())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot revoke API key: %v", err)
+	}
+
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+func toPBAPIKey(apiKey *APIKey) *pb.APIKey {
+	res := &pb.APIKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Role:      apiKey.Role,
+		Tenant:    apiKey.Tenant,
+		CreatedAt: timestamppb.New(apiKey.CreatedAt),
+	}
+	for _, permission := range apiKey.Permissions {
+		res.Permissions = append(res.Permissions, string(permission))
+	}
+	if !apiKey.ExpiresAt.IsZero() {
+		res.ExpiresAt = timestamppb.New(apiKey.ExpiresAt)
+	}
+	return res
+}
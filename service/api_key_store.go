@@ -0,0 +1,147 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An APIKey is a credential for services calling the API without a user account.
+// Only the hash of the key is stored.
+type APIKey struct {
+	ID   string
+	Name string
+	// Role grants the permissions of a role, unless Permissions are set.
+	Role        string
+	Permissions []Permission
+	Hash        string
+	// Tenant is the tenant that the key acts on, the DefaultTenant if empty.
+	Tenant    string
+	CreatedAt time.Time
+	// ExpiresAt is zero for keys that never expire.
+	ExpiresAt time.Time
+}
+
+// GenerateAPIKey returns a new random key and its ID. The ID is the prefix of the key,
+// so that the key can be found in a store without scanning the hashes.
+func GenerateAPIKey() (string, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := uuid.NewString()
+	return id, id + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// APIKeyID returns the ID of a key generated by GenerateAPIKey.
+func APIKeyID(key string) string {
+	id, _, _ := strings.Cut(key, ".")
+	return id
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of a key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether the key has expired at time now.
+func (key *APIKey) Expired(now time.Time) bool {
+	return !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt)
+}
+
+// Clone returns a clone of this API key
+func (key *APIKey) Clone() *APIKey {
+	clone := *key
+	clone.Permissions = append([]Permission(nil), key.Permissions...)
+	return &clone
+}
+
+// An APIKeyStore is an interface to store API keys.
+type APIKeyStore interface {
+	// Save saves an API key to the store.
+	Save(key *APIKey) error
+	// Find finds an API key by ID.
+	Find(id string) (*APIKey, error)
+	// Delete deletes an API key by ID.
+	Delete(id string) error
+	// List returns all API keys ordered by creation time.
+	List() ([]*APIKey, error)
+}
+
+// InMemoryAPIKeyStore stores API keys in memory.
+type InMemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+// NewInMemoryAPIKeyStore returns a new InMemoryAPIKeyStore.
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+// Save saves an API key to the store.
+func (store *InMemoryAPIKeyStore) Save(key *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys[key.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.keys[key.ID] = key.Clone()
+	return nil
+}
+
+// Find finds an API key by ID.
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	key := store.keys[id]
+	if key == nil {
+		return nil, nil
+	}
+
+	return key.Clone(), nil
+}
+
+// Delete deletes an API key by ID.
+func (store *InMemoryAPIKeyStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys[id] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.keys, id)
+	return nil
+}
+
+// List returns all API keys ordered by creation time.
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		keys = append(keys, key.Clone())
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
@@ -0,0 +1,101 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIKeyServer(t *testing.T) {
+	t.Parallel()
+
+	policy := loadTestPolicy(t)
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	apiKeyServer := service.NewAPIKeyServer(apiKeyStore, policy)
+
+	interceptor := service.NewAuthInterceptor(newTestJWTManager(t), service.NewInMemoryRevocationStore(), policy)
+	interceptor.SetAPIKeyStore(apiKeyStore)
+
+	ctx := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "admin1", Role: service.RoleAdmin})
+
+	_, err := apiKeyServer.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "batch"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = apiKeyServer.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "batch", Role: "root"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	scoped, err := apiKeyServer.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{
+		Name:        "importer",
+		Permissions: []string{string(service.PermissionLaptopCreate)},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, scoped.GetKey())
+
+	stored, err := apiKeyStore.Find(scoped.GetApiKey().GetId())
+	require.NoError(t, err)
+	require.NotContains(t, stored.Hash, scoped.GetKey())
+	require.Equal(t, service.HashAPIKey(scoped.GetKey()), stored.Hash)
+
+	role, err := apiKeyServer.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "reader", Role: service.RoleUser})
+	require.NoError(t, err)
+
+	require.Equal(t, codes.OK, authorizeTestCall(interceptor, "/LaptopService/CreateLaptop", service.APIKeyHeader, scoped.GetKey()))
+	require.Equal(t, codes.PermissionDenied, authorizeTestCall(interceptor, "/LaptopService/SearchLaptop", service.APIKeyHeader, scoped.GetKey()))
+	require.Equal(t, codes.OK, authorizeTestCall(interceptor, "/LaptopService/SearchLaptop", service.APIKeyHeader, role.GetKey()))
+	require.Equal(t, codes.PermissionDenied, authorizeTestCall(interceptor, "/LaptopService/CreateLaptop", service.APIKeyHeader, role.GetKey()))
+
+	// the keys cannot be granted more permissions than their creator has
+	_, err = apiKeyServer.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "root", Role: service.RoleSuperAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	keyAdminCtx := service.ContextWithPrincipal(context.Background(), &service.Principal{
+		Username:    "admin1",
+		APIKeyID:    "key-admin",
+		Permissions: []service.Permission{service.PermissionAPIKeyAdmin},
+	})
+	_, err = apiKeyServer.CreateAPIKey(keyAdminCtx, &pb.CreateAPIKeyRequest{Name: "admin", Role: service.RoleAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = apiKeyServer.CreateAPIKey(keyAdminCtx, &pb.CreateAPIKeyRequest{
+		Name:        "importer",
+		Permissions: []string{string(service.PermissionLaptopCreate)},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = apiKeyServer.CreateAPIKey(keyAdminCtx, &pb.CreateAPIKeyRequest{
+		Name:        "key-admin",
+		Permissions: []string{string(service.PermissionAPIKeyAdmin)},
+	})
+	require.NoError(t, err)
+
+	forged := scoped.GetApiKey().GetId() + ".forged-secret"
+	require.Equal(t, codes.Unauthenticated, authorizeTestCall(interceptor, "/LaptopService/CreateLaptop", service.APIKeyHeader, forged))
+
+	list, err := apiKeyServer.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetApiKeys(), 3)
+
+	_, err = apiKeyServer.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{Id: scoped.GetApiKey().GetId()})
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthenticated, authorizeTestCall(interceptor, "/LaptopService/CreateLaptop", service.APIKeyHeader, scoped.GetKey()))
+
+	_, err = apiKeyServer.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{Id: scoped.GetApiKey().GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	id, key, err := service.GenerateAPIKey()
+	require.NoError(t, err)
+	err = apiKeyStore.Save(&service.APIKey{
+		ID:        id,
+		Name:      "expired",
+		Role:      service.RoleAdmin,
+		Hash:      service.HashAPIKey(key),
+		CreatedAt: time.Now().Add(-time.Hour),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthenticated, authorizeTestCall(interceptor, "/LaptopService/CreateLaptop", service.APIKeyHeader, key))
+}
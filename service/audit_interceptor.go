@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxAuditTargetIDs limits the number of target IDs recorded for a call,
+// such as a stream rating many laptops.
+const maxAuditTargetIDs = 100
+
+// maxAuditTargetDepth is the depth of the nested messages searched for target IDs.
+const maxAuditTargetDepth = 3
+
+// AuditInterceptor is a server interceptor recording the calls of the audited RPCs,
+// with their principal, target IDs and outcome. It must run before the AuthInterceptor,
+// so that the calls denied by the AuthInterceptor are recorded too.
+type AuditInterceptor struct {
+	methods map[string]bool
+	sinks   []AuditSink
+}
+
+// NewAuditInterceptor returns an AuditInterceptor recording the calls of the methods,
+// keyed by full method name, to the sinks.
+func NewAuditInterceptor(methods map[string]bool, sinks ...AuditSink) *AuditInterceptor {
+	return &AuditInterceptor{
+		methods: methods,
+		sinks:   sinks,
+	}
+}
+
+// Unary returns a server interceptor function to audit unary RPC.
+func (interceptor *AuditInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !interceptor.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		ctx, recorder := contextWithPrincipalRecorder(ctx)
+		res, err := handler(ctx, req)
+
+		var targetIDs []string
+		targetIDs = appendTargetIDs(targetIDs, req)
+		targetIDs = appendTargetIDs(targetIDs, res)
+		interceptor.record(ctx, info.FullMethod, start, recorder.principal, targetIDs, err)
+
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to audit stream RPC.
+func (interceptor *AuditInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !interceptor.methods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		start := time.Now()
+		ctx, recorder := contextWithPrincipalRecorder(ss.Context())
+		stream := &auditServerStream{ServerStream: ss, ctx: ctx}
+		err := handler(srv, stream)
+
+		interceptor.record(ctx, info.FullMethod, start, recorder.principal, stream.targetIDs(), err)
+		return err
+	}
+}
+
+func (interceptor *AuditInterceptor) record(
+	ctx context.Context,
+	method string,
+	start time.Time,
+	principal *Principal,
+	targetIDs []string,
+	err error,
+) {
+	entry := &AuditEntry{
+		ID:        uuid.NewString(),
+		Time:      start,
+		Method:    method,
+		IP:        peerIP(ctx),
+		TargetIDs: targetIDs,
+		Code:      status.Code(err).String(),
+	}
+	if principal != nil {
+		entry.Username = principal.Username
+		entry.Role = principal.Role
+		entry.APIKeyID = principal.APIKeyID
+		entry.Tenant = principal.Tenant
+		if entry.Tenant == "" {
+			entry.Tenant = DefaultTenant
+		}
+	}
+	if err != nil {
+		entry.Error = status.Convert(err).Message()
+	}
+
+	for _, sink := range interceptor.sinks {
+		err := sink.Write(entry)
+		if err != nil {
+			LoggerFromContext(ctx).Error("cannot write audit entry", "method", method, "error", err)
+		}
+	}
+}
+
+// auditServerStream is a grpc.ServerStream collecting the target IDs of the messages
+// it receives and sends.
+type auditServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+
+	mutex sync.Mutex
+	ids   []string
+}
+
+func (stream *auditServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *auditServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.collect(m)
+	}
+	return err
+}
+
+func (stream *auditServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.collect(m)
+	}
+	return err
+}
+
+func (stream *auditServerStream) collect(m interface{}) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.ids = appendTargetIDs(stream.ids, m)
+}
+
+func (stream *auditServerStream) targetIDs() []string {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	return stream.ids
+}
+
+// appendTargetIDs appends the values of the id, *_id, *_ids and username fields
+// of a message and of its nested messages.
+func appendTargetIDs(ids []string, m interface{}) []string {
+	message, ok := m.(proto.Message)
+	if !ok || message == nil {
+		return ids
+	}
+	return appendMessageTargetIDs(ids, message.ProtoReflect(), 0)
+}
+
+func appendMessageTargetIDs(ids []string, message protoreflect.Message, depth int) []string {
+	if !message.IsValid() {
+		return ids
+	}
+
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.IsMap():
+		case field.Kind() == protoreflect.MessageKind:
+			if depth >= maxAuditTargetDepth {
+				break
+			}
+			if field.IsList() {
+				list := value.List()
+				for i := 0; i < list.Len(); i++ {
+					ids = appendMessageTargetIDs(ids, list.Get(i).Message(), depth+1)
+				}
+			} else {
+				ids = appendMessageTargetIDs(ids, value.Message(), depth+1)
+			}
+		case field.Kind() == protoreflect.StringKind && isTargetIDField(string(field.Name())):
+			if field.IsList() {
+				list := value.List()
+				for i := 0; i < list.Len(); i++ {
+					ids = appendTargetID(ids, list.Get(i).String())
+				}
+			} else {
+				ids = appendTargetID(ids, value.String())
+			}
+		}
+		return len(ids) < maxAuditTargetIDs
+	})
+
+	return ids
+}
+
+func isTargetIDField(name string) bool {
+	return name == "id" || name == "username" || strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "_ids")
+}
+
+func appendTargetID(ids []string, id string) []string {
+	if id == "" || len(ids) >= maxAuditTargetIDs {
+		return ids
+	}
+
+	for _, other := range ids {
+		if other == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultAuditQueryLimit is the number of entries returned by QueryAuditLog without a limit.
+const defaultAuditQueryLimit = 100
+
+// AuditServer is the server to query the audit log.
+type AuditServer struct {
+	pb.UnimplementedAuditServiceServer
+	auditStore AuditStore
+}
+
+// NewAuditServer returns a new AuditServer querying the entries of auditStore.
+func NewAuditServer(auditStore AuditStore) *AuditServer {
+	return &AuditServer{
+		auditStore: auditStore,
+	}
+}
+
+// QueryAuditLog returns the audit entries of the caller's tenant matching the filters,
+// from the most recent. The calls that were not authenticated have no tenant, and are
+// only returned to the callers selecting all the tenants.
+func (server *AuditServer) QueryAuditLog(
+	ctx context.Context,
+	req *pb.QueryAuditLogRequest,
+) (*pb.QueryAuditLogResponse, error) {
+	if req.GetLimit() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must not be negative")
+	}
+
+	filter := AuditFilter{
+		Username: req.GetUsername(),
+		Method:   req.GetMethod(),
+		TargetID: req.GetTargetId(),
+		Code:     req.GetCode(),
+		Tenant:   TenantFromContext(ctx),
+		Limit:    int(req.GetLimit()),
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditQueryLimit
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return nil, status.Errorf(codes.InvalidArgument, "since must be before until")
+	}
+
+	entries, err := server.auditStore.Query(filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot query audit log: %v", err)
+	}
+
+	res := &pb.QueryAuditLogResponse{}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, toPBAuditEntry(entry))
+	}
+	return res, nil
+}
+
+func toPBAuditEntry(entry *AuditEntry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Id:        entry.ID,
+		Time:      timestamppb.New(entry.Time),
+		Method:    entry.Method,
+		Username:  entry.Username,
+		Role:      entry.Role,
+		ApiKeyId:  entry.APIKeyID,
+		Tenant:    entry.Tenant,
+		Ip:        entry.IP,
+		TargetIds: entry.TargetIDs,
+		Code:      entry.Code,
+		Error:     entry.Error,
+	}
+}
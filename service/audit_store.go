@@ -0,0 +1,184 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultAuditRetention is the number of entries an InMemoryAuditStore keeps.
+const DefaultAuditRetention = 10000
+
+// An AuditEntry records a call of an audited RPC.
+type AuditEntry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Username, Role, APIKeyID and Tenant identify the principal of the call,
+	// and are empty for the calls that were not authenticated.
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// TargetIDs are the IDs and usernames found in the requests and responses.
+	TargetIDs []string `json:"target_ids,omitempty"`
+	// Code is the name of the status code of the call, such as OK or PermissionDenied.
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// An AuditFilter selects audit entries. Its zero fields match every entry.
+type AuditFilter struct {
+	Username string
+	Method   string
+	TargetID string
+	Code     string
+	// Tenant only matches the entries of the tenant, unless it is AllTenants.
+	Tenant string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Matches reports whether the entry is selected by the filter.
+func (filter *AuditFilter) Matches(entry *AuditEntry) bool {
+	if filter.Username != "" && entry.Username != filter.Username {
+		return false
+	}
+
+	if filter.Method != "" && entry.Method != filter.Method {
+		return false
+	}
+
+	if filter.Code != "" && entry.Code != filter.Code {
+		return false
+	}
+
+	if filter.Tenant != "" && filter.Tenant != AllTenants && entry.Tenant != filter.Tenant {
+		return false
+	}
+
+	if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && !entry.Time.Before(filter.Until) {
+		return false
+	}
+
+	if filter.TargetID != "" {
+		for _, id := range entry.TargetIDs {
+			if id == filter.TargetID {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// An AuditSink receives the audit entries.
+type AuditSink interface {
+	// Write records an audit entry.
+	Write(entry *AuditEntry) error
+}
+
+// An AuditStore is an AuditSink that can be queried.
+type AuditStore interface {
+	AuditSink
+	// Query returns the entries matching the filter, from the most recent.
+	Query(filter AuditFilter) ([]*AuditEntry, error)
+}
+
+// InMemoryAuditStore keeps the last audit entries in memory.
+type InMemoryAuditStore struct {
+	mutex     sync.RWMutex
+	entries   []*AuditEntry
+	retention int
+}
+
+// NewInMemoryAuditStore returns a new InMemoryAuditStore keeping the last retention entries.
+func NewInMemoryAuditStore(retention int) *InMemoryAuditStore {
+	return &InMemoryAuditStore{
+		retention: retention,
+	}
+}
+
+// Write records an audit entry.
+func (store *InMemoryAuditStore) Write(entry *AuditEntry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.entries = append(store.entries, entry.Clone())
+	if len(store.entries) > store.retention {
+		store.entries = store.entries[len(store.entries)-store.retention:]
+	}
+	return nil
+}
+
+// Query returns the entries matching the filter, from the most recent.
+func (store *InMemoryAuditStore) Query(filter AuditFilter) ([]*AuditEntry, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var entries []*AuditEntry
+	for i := len(store.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+
+		if filter.Matches(store.entries[i]) {
+			entries = append(entries, store.entries[i].Clone())
+		}
+	}
+	return entries, nil
+}
+
+// Clone returns a clone of this audit entry
+func (entry *AuditEntry) Clone() *AuditEntry {
+	clone := *entry
+	clone.TargetIDs = append([]string(nil), entry.TargetIDs...)
+	return &clone
+}
+
+// FileAuditSink appends the audit entries to a file, one JSON object per line.
+type FileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileAuditSink opens the file to append audit entries to, creating it if needed.
+func NewFileAuditSink(filename string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log file: %w", err)
+	}
+
+	return &FileAuditSink{file: file}, nil
+}
+
+// Write appends an audit entry to the file.
+func (sink *FileAuditSink) Write(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit entry: %w", err)
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	_, err = sink.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (sink *FileAuditSink) Close() error {
+	return sink.file.Close()
+}
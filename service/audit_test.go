@@ -0,0 +1,187 @@
+package service_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAuditedMethods(t *testing.T) {
+	t.Parallel()
+
+	audited := service.AuditedMethods(protoregistry.GlobalFiles)
+	require.True(t, audited["/LaptopService/CreateLaptop"])
+	require.True(t, audited["/LaptopService/RateLaptop"])
+	require.True(t, audited["/AuthService/Login"])
+	require.True(t, audited["/AuthService/RefreshToken"])
+	require.False(t, audited["/LaptopService/SearchLaptop"])
+	require.False(t, audited["/AuditService/QueryAuditLog"])
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := newTestJWTManager(t)
+	auditStore := service.NewInMemoryAuditStore(service.DefaultAuditRetention)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	fileSink, err := service.NewFileAuditSink(auditFile)
+	require.NoError(t, err)
+	t.Cleanup(func() { fileSink.Close() })
+
+	auditInterceptor := service.NewAuditInterceptor(service.AuditedMethods(protoregistry.GlobalFiles), auditStore, fileSink)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auditInterceptor.Unary(), authInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(auditInterceptor.Stream(), authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	callContext := func(user *service.User) context.Context {
+		token, err := jwtManager.GenerateToken(user)
+		require.NoError(t, err)
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+	}
+	admin := &service.User{Username: "admin1", Role: service.RoleAdmin}
+	user := &service.User{Username: "user1", Role: service.RoleUser}
+
+	laptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(callContext(admin), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteLaptop(callContext(user), &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := laptopClient.RateLaptop(callContext(user))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Error(t, err)
+
+	// searches are not audited
+	search, err := laptopClient.SearchLaptop(callContext(user), &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1e9}})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.NoError(t, err)
+
+	// the stream is recorded once the RPC returns
+	require.Eventually(t, func() bool {
+		entries, err := auditStore.Query(service.AuditFilter{})
+		return err == nil && len(entries) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	entries, err := auditStore.Query(service.AuditFilter{})
+	require.NoError(t, err)
+
+	rated := entries[0]
+	require.Equal(t, "/LaptopService/RateLaptop", rated.Method)
+	require.Equal(t, "user1", rated.Username)
+	require.Equal(t, []string{laptop.GetId()}, rated.TargetIDs)
+	require.Equal(t, codes.OK.String(), rated.Code)
+
+	denied := entries[1]
+	require.Equal(t, "/LaptopService/DeleteLaptop", denied.Method)
+	require.Equal(t, "user1", denied.Username)
+	require.Equal(t, codes.PermissionDenied.String(), denied.Code)
+	require.NotEmpty(t, denied.Error)
+
+	created := entries[2]
+	require.Equal(t, "/LaptopService/CreateLaptop", created.Method)
+	require.Equal(t, "admin1", created.Username)
+	require.Equal(t, service.RoleAdmin, created.Role)
+	require.Equal(t, service.DefaultTenant, created.Tenant)
+	require.Equal(t, []string{laptop.GetId()}, created.TargetIDs)
+
+	file, err := os.Open(auditFile)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var lines []service.AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry service.AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		lines = append(lines, entry)
+	}
+	require.Len(t, lines, 3)
+	require.Equal(t, created.ID, lines[0].ID)
+}
+
+func TestAuditServer(t *testing.T) {
+	t.Parallel()
+
+	auditStore := service.NewInMemoryAuditStore(service.DefaultAuditRetention)
+	for _, entry := range []*service.AuditEntry{
+		{ID: "1", Method: "/AuthService/Login", TargetIDs: []string{"alice"}, Code: "Unauthenticated"},
+		{ID: "2", Method: "/LaptopService/CreateLaptop", Username: "eu-admin", Tenant: "eu", TargetIDs: []string{"laptop-1"}, Code: "OK"},
+		{ID: "3", Method: "/LaptopService/DeleteLaptop", Username: "us-admin", Tenant: "us", TargetIDs: []string{"laptop-2"}, Code: "OK"},
+		{ID: "4", Method: "/LaptopService/UpdateLaptop", Username: "eu-admin", Tenant: "eu", TargetIDs: []string{"laptop-1"}, Code: "NotFound"},
+	} {
+		entry.Time = time.Now()
+		require.NoError(t, auditStore.Write(entry))
+	}
+
+	auditServer := service.NewAuditServer(auditStore)
+	ctx := context.Background()
+	euAdminCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "eu-admin", Role: service.RoleAdmin, Tenant: "eu"})
+	superAdminCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "root", Role: service.RoleSuperAdmin, Tenant: service.AllTenants})
+
+	ids := func(res *pb.QueryAuditLogResponse) []string {
+		var ids []string
+		for _, entry := range res.GetEntries() {
+			ids = append(ids, entry.GetId())
+		}
+		return ids
+	}
+
+	res, err := auditServer.QueryAuditLog(euAdminCtx, &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4", "2"}, ids(res))
+
+	res, err = auditServer.QueryAuditLog(euAdminCtx, &pb.QueryAuditLogRequest{Code: "OK", TargetId: "laptop-1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, ids(res))
+
+	res, err = auditServer.QueryAuditLog(superAdminCtx, &pb.QueryAuditLogRequest{Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4", "3", "2"}, ids(res))
+
+	_, err = auditServer.QueryAuditLog(superAdminCtx, &pb.QueryAuditLogRequest{Limit: -1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err = auditServer.QueryAuditLog(superAdminCtx, &pb.QueryAuditLogRequest{Method: "/AuthService/Login"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids(res))
+
+	since := timestamppb.Now()
+	_, err = auditServer.QueryAuditLog(superAdminCtx, &pb.QueryAuditLogRequest{Since: since, Until: since})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
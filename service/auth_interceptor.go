@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
-	"log"
+	"crypto/subtle"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -10,15 +13,64 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// APIKeyHeader is the metadata key of the API keys.
+const APIKeyHeader = "x-api-key"
+
+const bearerPrefix = "Bearer "
+
 // AuthInterceptor is a server interceptor for authentication and authorization.
 type AuthInterceptor struct {
 	jwtManager      *JWTManager
-	accessibleRoles map[string][]string
+	revocationStore RevocationStore
+	policy          atomic.Pointer[Policy]
+	apiKeyStore     APIKeyStore
+	userStore       UserStore
+
+	certificateIdentities *CertificateIdentities
+	tenants               TenantSet
 }
 
 // NewAuthInterceptor instantiates a AuthInterceptor object.
-func NewAuthInterceptor(jwtManager *JWTManager, accessibleRoles map[string][]string) *AuthInterceptor {
-	return &AuthInterceptor{jwtManager, accessibleRoles}
+func NewAuthInterceptor(
+	jwtManager *JWTManager,
+	revocationStore RevocationStore,
+	policy *Policy,
+) *AuthInterceptor {
+	interceptor := &AuthInterceptor{
+		jwtManager:      jwtManager,
+		revocationStore: revocationStore,
+		tenants:         TenantSet{DefaultTenant: true},
+	}
+	interceptor.policy.Store(policy)
+	return interceptor
+}
+
+// SetPolicy replaces the authorization policy of the next RPCs.
+func (interceptor *AuthInterceptor) SetPolicy(policy *Policy) {
+	interceptor.policy.Store(policy)
+}
+
+// SetTenants sets the tenants that the callers with the tenant:admin permission can select.
+func (interceptor *AuthInterceptor) SetTenants(tenants TenantSet) {
+	interceptor.tenants = tenants
+}
+
+// SetAPIKeyStore accepts the API keys of the store in the x-api-key metadata,
+// in place of an access token.
+func (interceptor *AuthInterceptor) SetAPIKeyStore(apiKeyStore APIKeyStore) {
+	interceptor.apiKeyStore = apiKeyStore
+}
+
+// SetUserStore rejects the access tokens of the users that were deleted or disabled
+// since the tokens were issued.
+func (interceptor *AuthInterceptor) SetUserStore(userStore UserStore) {
+	interceptor.userStore = userStore
+}
+
+// SetCertificateIdentities authenticates the callers that send no credentials in the
+// metadata with the identity of their verified client certificate.
+func (interceptor *AuthInterceptor) SetCertificateIdentities(identities *CertificateIdentities) {
+	interceptor.certificateIdentities = identities
 }
 
 // Unary return a server interceptor function to authenticate and authorize unary RPC.
@@ -29,9 +81,7 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		log.Println("---> unary interceptor: ", info.FullMethod)
-
-		err := interceptor.authorize(ctx, info.FullMethod)
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
@@ -47,44 +97,174 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		log.Println("---> stream interceptor: ", info.FullMethod)
-
-		err := interceptor.authorize(ss.Context(), info.FullMethod)
+		ctx, err := interceptor.authorize(ss.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
-		return handler(srv, ss)
+		return handler(srv, &serverStreamWithContext{ss, ctx})
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
-	accessibleRoles, ok := interceptor.accessibleRoles[method]
+// authorize checks that the policy allows the caller to access the method, and returns
+// a context carrying the caller's Principal when the method requires authentication.
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	rule, ok := interceptor.policy.Load().MethodPolicy(method)
 	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	}
+
+	if rule.Public {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	authCtx, span := startSpan(ctx, "AuthInterceptor.authenticate")
+	principal, err := interceptor.authenticate(authCtx, md)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	recordPrincipal(ctx, principal)
+
+	if !interceptor.hasPermissions(principal, rule.Permissions) {
+		return nil, status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	}
+
+	err = interceptor.selectTenant(principal, md)
+	if err != nil {
+		return nil, err
+	}
+
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// selectTenant sets the tenant that the RPC acts on. Callers can only act on their own
+// tenant, unless they have the tenant:admin permission and select another tenant, or
+// all of them, in the x-tenant metadata.
+func (interceptor *AuthInterceptor) selectTenant(principal *Principal, md metadata.MD) error {
+	if principal.Tenant == "" {
+		principal.Tenant = DefaultTenant
+	}
+	principal.CrossTenant = interceptor.hasPermissions(principal, []Permission{PermissionTenantAdmin})
+
+	values := md.Get(TenantHeader)
+	if len(values) == 0 || values[0] == principal.Tenant {
 		return nil
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	if !principal.CrossTenant {
+		return status.Errorf(codes.PermissionDenied, "no permission to access tenant %s", values[0])
+	}
+
+	tenant := values[0]
+	if tenant != AllTenants && !interceptor.tenants[tenant] {
+		return status.Errorf(codes.NotFound, "tenant %s is not found", tenant)
+	}
+
+	principal.Tenant = tenant
+	return nil
+}
+
+// authenticate returns the caller authenticated by an API key, an access token, or
+// else by the identity of its client certificate.
+func (interceptor *AuthInterceptor) authenticate(ctx context.Context, md metadata.MD) (*Principal, error) {
+	if values := md.Get(APIKeyHeader); len(values) > 0 {
+		return interceptor.authenticateAPIKey(values[0])
 	}
 
 	values := md.Get("authorization")
+	if len(values) == 0 && interceptor.certificateIdentities != nil {
+		cert, ok := peerCertificate(ctx)
+		if ok {
+			principal, ok := interceptor.certificateIdentities.Lookup(cert)
+			if ok {
+				return principal, nil
+			}
+		}
+	}
+
+	return interceptor.authenticateToken(values)
+}
+
+// authenticateToken verifies the access token of the authorization header,
+// with or without the Bearer scheme.
+func (interceptor *AuthInterceptor) authenticateToken(values []string) (*Principal, error) {
 	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
 	}
 
 	accessToken := values[0]
+	if len(accessToken) > len(bearerPrefix) && strings.EqualFold(accessToken[:len(bearerPrefix)], bearerPrefix) {
+		accessToken = accessToken[len(bearerPrefix):]
+	}
+
 	claims, err := interceptor.jwtManager.Verify(accessToken)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
 	}
 
-	for _, role := range accessibleRoles {
-		if role == claims.Role {
-			return nil
+	revoked, err := interceptor.revocationStore.IsRevoked(claims.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot check token revocation: %v", err)
+	}
+	if revoked {
+		return nil, status.Errorf(codes.Unauthenticated, "access token has been revoked")
+	}
+
+	if interceptor.userStore != nil {
+		user, err := interceptor.userStore.Find(claims.Username)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+		}
+		if user == nil || user.Disabled {
+			return nil, status.Errorf(codes.Unauthenticated, "user %s cannot log in", claims.Username)
 		}
 	}
 
-	return status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	return &Principal{Username: claims.Username, Role: claims.Role, Tenant: claims.Tenant}, nil
+}
+
+// authenticateAPIKey finds the API key by its ID, and compares its hash in constant time.
+func (interceptor *AuthInterceptor) authenticateAPIKey(key string) (*Principal, error) {
+	if interceptor.apiKeyStore == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "API keys are not accepted")
+	}
+
+	apiKey, err := interceptor.apiKeyStore.Find(APIKeyID(key))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+
+	if apiKey == nil || subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(HashAPIKey(key))) != 1 {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid")
+	}
+
+	if apiKey.Expired(time.Now()) {
+		return nil, status.Errorf(codes.Unauthenticated, "API key has expired")
+	}
+
+	principal := &Principal{
+		Role:        apiKey.Role,
+		APIKeyID:    apiKey.ID,
+		Permissions: apiKey.Permissions,
+		Tenant:      apiKey.Tenant,
+	}
+	return principal, nil
+}
+
+// hasPermissions reports whether the principal is granted all the permissions,
+// either by its own permissions or by its role.
+func (interceptor *AuthInterceptor) hasPermissions(principal *Principal, permissions []Permission) bool {
+	return interceptor.policy.Load().PrincipalHasPermissions(principal, permissions)
+}
+
+// serverStreamWithContext is a grpc.ServerStream whose context is replaced.
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStreamWithContext) Context() context.Context {
+	return stream.ctx
 }
@@ -0,0 +1,419 @@
+package service_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserManagementServer(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	jwtManager := newTestJWTManager(t)
+	authServer := service.NewAuthServer(userStore, jwtManager, service.NewInMemoryRevocationStore())
+	policy := loadTestPolicy(t)
+	err := policy.SetRole("auditor", []service.Permission{service.PermissionAuditRead})
+	require.NoError(t, err)
+	authServer.SetPolicy(policy)
+
+	ctx := context.Background()
+	adminCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "admin", Role: service.RoleAdmin})
+
+	_, err = authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	_, err = authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "other-secret"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	// alice changes her password
+	aliceCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "alice", Role: service.RoleUser})
+	_, err = authServer.ChangePassword(aliceCtx, &pb.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "new-secret"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = authServer.ChangePassword(aliceCtx, &pb.ChangePasswordRequest{OldPassword: "alice-secret", NewPassword: "new-secret"})
+	require.NoError(t, err)
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "new-secret"})
+	require.NoError(t, err)
+
+	// an admin manages the accounts
+	_, err = authServer.CreateUser(adminCtx, &pb.CreateUserRequest{Username: "bob", Password: "bob-secret", Role: "root"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = authServer.CreateUser(adminCtx, &pb.CreateUserRequest{Username: "bob", Password: "bob-secret", Role: service.RoleAdmin})
+	require.NoError(t, err)
+
+	// the roles of the policy can be granted to users
+	updated, err := authServer.UpdateUserRole(adminCtx, &pb.UpdateUserRoleRequest{Username: "bob", Role: "auditor"})
+	require.NoError(t, err)
+	require.Equal(t, "auditor", updated.GetUser().GetRole())
+
+	updated, err = authServer.UpdateUserRole(adminCtx, &pb.UpdateUserRoleRequest{Username: "bob", Role: service.RoleUser})
+	require.NoError(t, err)
+	require.Equal(t, service.RoleUser, updated.GetUser().GetRole())
+
+	// the callers cannot grant roles with permissions that they do not have
+	userAdminCtx := service.ContextWithPrincipal(ctx, &service.Principal{
+		Username:    "admin",
+		APIKeyID:    "user-admin",
+		Permissions: []service.Permission{service.PermissionUserAdmin},
+	})
+	_, err = authServer.CreateUser(userAdminCtx, &pb.CreateUserRequest{Username: "mallory", Password: "mallory-secret", Role: service.RoleAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = authServer.UpdateUserRole(userAdminCtx, &pb.UpdateUserRoleRequest{Username: "bob", Role: service.RoleAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = authServer.UpdateUserRole(adminCtx, &pb.UpdateUserRoleRequest{Username: "bob", Role: service.RoleSuperAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = authServer.DisableUser(adminCtx, &pb.DisableUserRequest{Username: "admin"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	login, err := authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "new-secret"})
+	require.NoError(t, err)
+	searchPolicy, err := service.ParsePolicy([]byte(`{
+		"roles": {"user": ["laptop:read"]},
+		"methods": {"/LaptopService/SearchLaptop": {"permissions": ["laptop:read"]}}
+	}`))
+	require.NoError(t, err)
+	interceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), searchPolicy)
+	interceptor.SetUserStore(userStore)
+	require.Equal(t, codes.OK, authorizeTestCall(interceptor, "/LaptopService/SearchLaptop", "authorization", login.GetAccessToken()))
+
+	disabled, err := authServer.DisableUser(adminCtx, &pb.DisableUserRequest{Username: "alice"})
+	require.NoError(t, err)
+	require.True(t, disabled.GetUser().GetDisabled())
+
+	// the login of a disabled user fails like with an incorrect password
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "new-secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, wrongPasswordErr := authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong-secret"})
+	require.Equal(t, status.Convert(wrongPasswordErr).Message(), status.Convert(err).Message())
+
+	// the tokens issued before alice was disabled are rejected
+	require.Equal(t, codes.Unauthenticated, authorizeTestCall(interceptor, "/LaptopService/SearchLaptop", "authorization", login.GetAccessToken()))
+
+	// the concurrent changes of a user are not lost
+	var wg sync.WaitGroup
+	for _, role := range []string{service.RoleAdmin, service.RoleUser, service.RoleAdmin} {
+		role := role
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := authServer.UpdateUserRole(adminCtx, &pb.UpdateUserRoleRequest{Username: "alice", Role: role})
+			require.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := authServer.ChangePassword(aliceCtx, &pb.ChangePasswordRequest{OldPassword: "new-secret", NewPassword: "new-secret"})
+			require.NotEqual(t, codes.Internal, status.Code(err))
+		}()
+	}
+	wg.Wait()
+
+	user, err := userStore.Find("alice")
+	require.NoError(t, err)
+	require.True(t, user.Disabled)
+	_, err = authServer.UpdateUserRole(adminCtx, &pb.UpdateUserRoleRequest{Username: "alice", Role: service.RoleUser})
+	require.NoError(t, err)
+
+	users, err := authServer.ListUsers(adminCtx, &pb.ListUsersRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []*pb.UserAccount{
+		{Username: "alice", Role: service.RoleUser, Disabled: true, Tenant: service.DefaultTenant},
+		{Username: "bob", Role: service.RoleUser, Tenant: service.DefaultTenant},
+	}, users.GetUsers())
+}
+
+func TestRefreshTokenServer(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	jwtManager := newTestJWTManager(t)
+	revocationStore := service.NewInMemoryRevocationStore()
+	authServer := service.NewAuthServer(userStore, jwtManager, revocationStore)
+
+	ctx := context.Background()
+	_, err := authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	login, err := authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+	require.NotEmpty(t, login.GetRefreshToken())
+
+	// the tokens cannot be used in place of each other
+	_, err = authServer.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetAccessToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = jwtManager.Verify(login.GetRefreshToken())
+	require.Error(t, err)
+
+	refreshed, err := authServer.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.NoError(t, err)
+	claims, err := jwtManager.Verify(refreshed.GetAccessToken())
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Username)
+
+	// a refresh token can be used only once
+	_, err = authServer.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	policy, err := service.ParsePolicy([]byte(`{
+		"roles": {"user": ["account:write"]},
+		"methods": {"/AuthService/ChangePassword": {"permissions": ["account:write"]}}
+	}`))
+	require.NoError(t, err)
+	interceptor := service.NewAuthInterceptor(jwtManager, revocationStore, policy)
+	call := func(accessToken string) error {
+		ctx := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", accessToken))
+		info := &grpc.UnaryServerInfo{FullMethod: "/AuthService/ChangePassword"}
+		_, err := interceptor.Unary()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+	require.NoError(t, call(refreshed.GetAccessToken()))
+
+	_, err = authServer.Logout(ctx, &pb.LogoutRequest{
+		AccessToken:  refreshed.GetAccessToken(),
+		RefreshToken: refreshed.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, codes.Unauthenticated, status.Code(call(refreshed.GetAccessToken())))
+	_, err = authServer.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: refreshed.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRefreshTokenConcurrent(t *testing.T) {
+	t.Parallel()
+
+	authServer := service.NewAuthServer(service.NewInMemoryUserStore(), newTestJWTManager(t), service.NewInMemoryRevocationStore())
+
+	ctx := context.Background()
+	_, err := authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	login, err := authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	// only one of the concurrent refreshes with the same token succeeds
+	const refreshes = 20
+	results := make(chan codes.Code, refreshes)
+	var wg sync.WaitGroup
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := authServer.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+			results <- status.Code(err)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for code := range results {
+		if code == codes.OK {
+			succeeded++
+		} else {
+			require.Equal(t, codes.Unauthenticated, code)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+}
+
+func TestRevocationStoreSweep(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRevocationStore()
+	store.SetSweepInterval(100 * time.Millisecond)
+
+	revoke := func(tokenID string, expiresAt time.Time) {
+		alreadyRevoked, err := store.Revoke(tokenID, expiresAt)
+		require.NoError(t, err)
+		require.False(t, alreadyRevoked)
+	}
+	isRevoked := func(tokenID string) bool {
+		revoked, err := store.IsRevoked(tokenID)
+		require.NoError(t, err)
+		return revoked
+	}
+
+	revoke("expired", time.Now().Add(-time.Second))
+	revoke("valid", time.Now().Add(time.Hour))
+
+	// the expired tokens are only forgotten once per interval
+	require.True(t, isRevoked("expired"))
+
+	time.Sleep(150 * time.Millisecond)
+	revoke("other", time.Now().Add(time.Hour))
+	require.False(t, isRevoked("expired"))
+	require.True(t, isRevoked("valid"))
+}
+
+func TestJWTManagerKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	user := &service.User{Username: "alice", Role: service.RoleUser}
+
+	privateKey1, publicKey1 := newTestKeyPair(t)
+	manager, err := service.NewJWTManager(privateKey1, publicKey1, 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+	keyID1 := manager.SigningKeyID()
+
+	token1, err := manager.GenerateToken(user)
+	require.NoError(t, err)
+
+	privateKey2, publicKey2 := newTestKeyPair(t)
+	err = manager.RotateSigningKey(privateKey2, publicKey1)
+	require.Error(t, err)
+	err = manager.RotateSigningKey(privateKey2, publicKey2)
+	require.NoError(t, err)
+	require.NotEqual(t, keyID1, manager.SigningKeyID())
+
+	token2, err := manager.GenerateToken(user)
+	require.NoError(t, err)
+
+	// the tokens signed with the previous key are still valid
+	claims, err := manager.Verify(token1)
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Subject)
+	require.Equal(t, service.DefaultJWTIssuer, claims.Issuer)
+	require.NotEmpty(t, claims.Id)
+
+	_, err = manager.Verify(token2)
+	require.NoError(t, err)
+
+	// until the previous key is retired
+	err = manager.RemoveVerificationKey(keyID1)
+	require.NoError(t, err)
+	_, err = manager.Verify(token1)
+	require.Error(t, err)
+	_, err = manager.Verify(token2)
+	require.NoError(t, err)
+
+	// another issuer or audience is rejected
+	other, err := service.NewJWTManager(privateKey2, publicKey2, 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+	other.SetIssuer(service.DefaultJWTIssuer, "other-service")
+	_, err = other.Verify(token2)
+	require.Error(t, err)
+}
+
+func TestJWTManagerReplaceKeys(t *testing.T) {
+	t.Parallel()
+
+	user := &service.User{Username: "alice", Role: service.RoleUser}
+
+	privateKey1, publicKey1 := newTestKeyPair(t)
+	manager, err := service.NewJWTManager(privateKey1, publicKey1, 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+	keyID1 := manager.SigningKeyID()
+
+	_, verificationKey := newTestKeyPair(t)
+	err = manager.AddVerificationKey(verificationKey)
+	require.NoError(t, err)
+	require.Len(t, manager.JWKS().Keys, 2)
+
+	token1, err := manager.GenerateToken(user)
+	require.NoError(t, err)
+
+	// nothing is replaced if a key is invalid
+	privateKey2, publicKey2 := newTestKeyPair(t)
+	err = manager.ReplaceKeys(privateKey2, publicKey2, "invalid key")
+	require.Error(t, err)
+	require.Equal(t, keyID1, manager.SigningKeyID())
+
+	_, newVerificationKey := newTestKeyPair(t)
+	err = manager.ReplaceKeys(privateKey2, publicKey2, newVerificationKey)
+	require.NoError(t, err)
+	require.NotEqual(t, keyID1, manager.SigningKeyID())
+
+	// the previous signing key is kept, and the verification keys are replaced
+	require.Len(t, manager.JWKS().Keys, 3)
+	_, err = manager.Verify(token1)
+	require.NoError(t, err)
+
+	token2, err := manager.GenerateToken(user)
+	require.NoError(t, err)
+	_, err = manager.Verify(token2)
+	require.NoError(t, err)
+
+	// reloading the same keys keeps the previous signing key
+	err = manager.ReplaceKeys(privateKey2, publicKey2, newVerificationKey)
+	require.NoError(t, err)
+	_, err = manager.Verify(token1)
+	require.NoError(t, err)
+
+	// until the signing key is replaced again
+	privateKey3, publicKey3 := newTestKeyPair(t)
+	err = manager.ReplaceKeys(privateKey3, publicKey3)
+	require.NoError(t, err)
+	require.Len(t, manager.JWKS().Keys, 2)
+	_, err = manager.Verify(token1)
+	require.Error(t, err)
+	_, err = manager.Verify(token2)
+	require.NoError(t, err)
+}
+
+func TestJWTManagerLeeway(t *testing.T) {
+	t.Parallel()
+
+	user := &service.User{Username: "alice", Role: service.RoleUser}
+	privateKey, publicKey := newTestKeyPair(t)
+
+	// the token expired 10 seconds ago
+	manager, err := service.NewJWTManager(privateKey, publicKey, -10*time.Second, time.Hour)
+	require.NoError(t, err)
+
+	token, err := manager.GenerateToken(user)
+	require.NoError(t, err)
+
+	_, err = manager.Verify(token)
+	require.NoError(t, err)
+
+	manager.SetLeeway(0)
+	_, err = manager.Verify(token)
+	require.Error(t, err)
+}
+
+func newTestJWTManager(t *testing.T) *service.JWTManager {
+	privateKey, publicKey := newTestKeyPair(t)
+
+	manager, err := service.NewJWTManager(privateKey, publicKey, 15*time.Minute, 24*time.Hour)
+	require.NoError(t, err)
+
+	return manager
+}
+
+func newTestKeyPair(t *testing.T) (string, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	return string(privatePEM), string(publicPEM)
+}
@@ -2,43 +2,573 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IkehAkinyemi/pcbook/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // AuthServer is the server for authentication.
 type AuthServer struct {
-	userStore  UserStore
-	jwtManager *JWTManager
+	userStore       UserStore
+	jwtManager      *JWTManager
+	revocationStore RevocationStore
+	loginThrottle   *LoginThrottle
+	passwordHasher  PasswordHasher
+	passwordPolicy  *PasswordPolicy
+	tenants         TenantSet
+	policy          atomic.Pointer[Policy]
+	// dummyHash is a hash of the passwordHasher, checked for the unknown usernames.
+	dummyMutex sync.Mutex
+	dummyHash  string
 	pb.UnimplementedAuthServiceServer
 }
 
 // NewAuthServer instantiates a new AuthServer object.
-func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
-	return &AuthServer{userStore: userStore, jwtManager: jwtManager}
+func NewAuthServer(userStore UserStore, jwtManager *JWTManager, revocationStore RevocationStore) *AuthServer {
+	return &AuthServer{
+		userStore:       userStore,
+		jwtManager:      jwtManager,
+		revocationStore: revocationStore,
+		loginThrottle:   NewLoginThrottle(DefaultLoginThrottleOptions),
+		passwordHasher:  DefaultPasswordHasher,
+		passwordPolicy:  &DefaultPasswordPolicy,
+		tenants:         TenantSet{DefaultTenant: true},
+	}
+}
+
+// SetTenants sets the tenants that users can register to.
+func (server *AuthServer) SetTenants(tenants TenantSet) {
+	server.tenants = tenants
+}
+
+// SetPolicy replaces the policy of the roles that the users can have, and that the admins
+// can grant. Without a policy, users can register with the built-in roles, but no role
+// can be granted.
+func (server *AuthServer) SetPolicy(policy *Policy) {
+	server.policy.Store(policy)
+}
+
+// SetLoginThrottle replaces the throttle of the failed logins.
+func (server *AuthServer) SetLoginThrottle(loginThrottle *LoginThrottle) {
+	server.loginThrottle = loginThrottle
 }
 
-// Login authenticates user, and generates access token for authorization
+// SetPasswordHasher replaces the hasher of the new passwords. The passwords hashed
+// by another hasher, or with other parameters, are rehashed on the next successful login.
+func (server *AuthServer) SetPasswordHasher(passwordHasher PasswordHasher) {
+	server.dummyMutex.Lock()
+	defer server.dummyMutex.Unlock()
+
+	server.passwordHasher = passwordHasher
+	server.dummyHash = ""
+}
+
+// SetPasswordPolicy replaces the policy that the passwords must satisfy on register
+// and on change. Existing passwords are not checked.
+func (server *AuthServer) SetPasswordPolicy(passwordPolicy *PasswordPolicy) {
+	server.passwordPolicy = passwordPolicy
+}
+
+// Login authenticates user, and generates access and refresh tokens for authorization.
+// Failed logins are throttled per username and per IP address, and all return the
+// same Unauthenticated error, so that they do not reveal whether the username exists.
 func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	now := time.Now()
+	ip := peerIP(ctx)
+
+	retryAfter, ok := server.loginThrottle.Allow(req.GetUsername(), ip, now)
+	if !ok {
+		server.verifyDummyPassword(req.GetPassword())
+		server.loginThrottle.Fail(req.GetUsername(), ip, "locked out", now)
+		return nil, status.Errorf(codes.Unauthenticated, "too many failed logins, retry in %v", retryAfter.Round(time.Second))
+	}
+
 	user, err := server.userStore.Find(req.GetUsername())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
 	}
 
-	if user == nil || !user.VerfiyPassword(req.GetPassword()) {
-		return nil, status.Errorf(codes.NotFound, "incorrect username/password")
+	if user == nil {
+		server.verifyDummyPassword(req.GetPassword())
+		server.loginThrottle.Fail(req.GetUsername(), ip, "unknown user", now)
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
+	}
+
+	if !user.VerfiyPassword(req.GetPassword()) {
+		server.loginThrottle.Fail(req.GetUsername(), ip, "incorrect password", now)
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
 	}
 
-	token, err := server.jwtManager.GenerateToken(user)
+	if user.Disabled {
+		server.loginThrottle.Fail(req.GetUsername(), ip, "disabled user", now)
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
+	}
+
+	server.loginThrottle.Succeed(user.Username)
+	server.upgradePasswordHash(ctx, user, req.GetPassword())
+
+	accessToken, refreshToken, err := server.generateTokens(user)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+		return nil, err
 	}
 
 	res := &pb.LoginResponse{
-		AccessToken: token,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+
+	return res, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+// The refresh token is revoked, so that it can be used only once.
+func (server *AuthServer) RefreshToken(
+	ctx context.Context,
+	req *pb.RefreshTokenRequest,
+) (*pb.RefreshTokenResponse, error) {
+	claims, err := server.jwtManager.VerifyRefreshToken(req.GetRefreshToken())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token is invalid: %v", err)
+	}
+
+	// the token is revoked before it is exchanged, so that concurrent refreshes with
+	// the same token cannot both succeed
+	alreadyRevoked, err := server.revoke(claims)
+	if err != nil {
+		return nil, err
+	}
+	if alreadyRevoked {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token has been revoked")
+	}
+
+	user, err := server.userStore.Find(claims.Username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil || user.Disabled {
+		return nil, status.Errorf(codes.Unauthenticated, "user %s cannot log in", claims.Username)
+	}
+
+	accessToken, refreshToken, err := server.generateTokens(user)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.RefreshTokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+
+	return res, nil
+}
+
+// Logout revokes the given access and refresh tokens. Tokens that are invalid or
+// already expired are ignored, since they cannot be used anyway.
+func (server *AuthServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	if req.GetAccessToken() == "" && req.GetRefreshToken() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "no token to revoke")
+	}
+
+	if req.GetAccessToken() != "" {
+		claims, err := server.jwtManager.Verify(req.GetAccessToken())
+		if err == nil {
+			_, err = server.revoke(claims)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if req.GetRefreshToken() != "" {
+		claims, err := server.jwtManager.VerifyRefreshToken(req.GetRefreshToken())
+		if err == nil {
+			_, err = server.revoke(claims)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &pb.LogoutResponse{}, nil
+}
+
+// Register creates a new account with the user role, in the requested tenant.
+func (server *AuthServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	tenant := req.GetTenant()
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+
+	if !server.tenants[tenant] {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown tenant: %s", tenant)
+	}
+
+	user, err := server.createUser(req.GetUsername(), req.GetPassword(), RoleUser, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.RegisterResponse{
+		User: toPBUserAccount(user),
+	}
+
+	return res, nil
+}
+
+// ChangePassword changes the password of the authenticated user.
+func (server *AuthServer) ChangePassword(
+	ctx context.Context,
+	req *pb.ChangePasswordRequest,
+) (*pb.ChangePasswordResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	user, err := server.findUser(principal.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.VerfiyPassword(req.GetOldPassword()) {
+		return nil, status.Errorf(codes.PermissionDenied, "incorrect password")
+	}
+
+	if req.GetNewPassword() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "new password is empty")
+	}
+
+	err = server.passwordPolicy.Validate(user.Username, req.GetNewPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	hashedPassword, err := server.passwordHasher.Hash(req.GetNewPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot hash password: %v", err)
+	}
+
+	// only the hash is replaced, unless the password was changed since it was verified
+	err = server.userStore.UpdatePassword(user.Username, user.HashedPassword, hashedPassword)
+	if err != nil {
+		return nil, userUpdateError(err)
+	}
+
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+// ListUsers returns the user accounts of the caller's tenant.
+func (server *AuthServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	users, err := server.userStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list users: %v", err)
+	}
+
+	res := &pb.ListUsersResponse{}
+	for _, user := range users {
+		if inTenant(ctx, user.Tenant) {
+			res.Users = append(res.Users, toPBUserAccount(user))
+		}
+	}
+
+	return res, nil
+}
+
+// CreateUser creates a new account in the caller's tenant, with a role whose permissions
+// the caller all has.
+func (server *AuthServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant == AllTenants {
+		return nil, status.Errorf(codes.InvalidArgument, "user must belong to a single tenant")
+	}
+
+	err := server.checkRoleGrant(ctx, req.GetRole())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := server.createUser(req.GetUsername(), req.GetPassword(), req.GetRole(), tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.CreateUserResponse{
+		User: toPBUserAccount(user),
 	}
 
 	return res, nil
 }
+
+// UpdateUserRole changes the role of a user of the caller's tenant to a role whose
+// permissions the caller all has.
+func (server *AuthServer) UpdateUserRole(
+	ctx context.Context,
+	req *pb.UpdateUserRoleRequest,
+) (*pb.UpdateUserRoleResponse, error) {
+	if !server.isValidRole(req.GetRole()) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role: %s", req.GetRole())
+	}
+
+	err := server.checkRoleGrant(ctx, req.GetRole())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := server.modifyTenantUser(ctx, req.GetUsername(), func(user *User) error {
+		user.Role = req.GetRole()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.UpdateUserRoleResponse{
+		User: toPBUserAccount(user),
+	}
+
+	return res, nil
+}
+
+// DisableUser prevents a user of the caller's tenant from logging in.
+func (server *AuthServer) DisableUser(ctx context.Context, req *pb.DisableUserRequest) (*pb.DisableUserResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if ok && principal.Username == req.GetUsername() {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot disable your own account")
+	}
+
+	user, err := server.modifyTenantUser(ctx, req.GetUsername(), func(user *User) error {
+		user.Disabled = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.DisableUserResponse{
+		User: toPBUserAccount(user),
+	}
+
+	return res, nil
+}
+
+// ListFailedLogins returns the audit trail of the failed logins. Only super admins
+// selecting all the tenants see the failed logins of unknown usernames.
+func (server *AuthServer) ListFailedLogins(
+	ctx context.Context,
+	req *pb.ListFailedLoginsRequest,
+) (*pb.ListFailedLoginsResponse, error) {
+	users, err := server.userStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list users: %v", err)
+	}
+
+	usernames := make(map[string]bool, len(users))
+	for _, user := range users {
+		if inTenant(ctx, user.Tenant) {
+			usernames[user.Username] = true
+		}
+	}
+	allTenants := TenantFromContext(ctx) == AllTenants
+
+	res := &pb.ListFailedLoginsResponse{}
+	for _, failedLogin := range server.loginThrottle.FailedLogins(req.GetUsername()) {
+		if !allTenants && !usernames[failedLogin.Username] {
+			continue
+		}
+		res.FailedLogins = append(res.FailedLogins, &pb.FailedLogin{
+			Username: failedLogin.Username,
+			Ip:       failedLogin.IP,
+			Reason:   failedLogin.Reason,
+			Time:     timestamppb.New(failedLogin.Time),
+		})
+	}
+
+	return res, nil
+}
+
+func (server *AuthServer) generateTokens(user *User) (string, string, error) {
+	accessToken, err := server.jwtManager.GenerateToken(user)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	refreshToken, err := server.jwtManager.GenerateRefreshToken(user)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "cannot generate refresh token")
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// revoke revokes the token of claims, and reports whether it was already revoked.
+func (server *AuthServer) revoke(claims *UserClaims) (bool, error) {
+	alreadyRevoked, err := server.revocationStore.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "cannot revoke token: %v", err)
+	}
+
+	return alreadyRevoked, nil
+}
+
+func (server *AuthServer) createUser(username, password, role, tenant string) (*User, error) {
+	if username == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username is empty")
+	}
+
+	if password == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "password is empty")
+	}
+
+	if !server.isValidRole(role) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role: %s", role)
+	}
+
+	err := server.passwordPolicy.Validate(username, password)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	user, err := NewUserWithHasher(username, password, role, server.passwordHasher)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create user: %v", err)
+	}
+	user.Tenant = tenant
+
+	err = server.userStore.Save(user)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrAlreadyExists) {
+			code = codes.AlreadyExists
+		}
+		return nil, status.Errorf(code, "cannot save user: %v", err)
+	}
+
+	return user, nil
+}
+
+// upgradePasswordHash rehashes the password of a user who just logged in, if it was
+// hashed by another hasher or with other parameters. Failures are only logged, since
+// the old hash is still valid.
+func (server *AuthServer) upgradePasswordHash(ctx context.Context, user *User, password string) {
+	if !server.passwordHasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+
+	hashedPassword, err := server.passwordHasher.Hash(password)
+	if err != nil {
+		LoggerFromContext(ctx).Error("cannot rehash password", "username", user.Username, "error", err)
+		return
+	}
+
+	// only the hash is replaced, unless the password was changed during the rehash,
+	// so that the other changes made to the user in the meantime are kept
+	err = server.userStore.UpdatePassword(user.Username, user.HashedPassword, hashedPassword)
+	if err != nil {
+		LoggerFromContext(ctx).Error("cannot save rehashed password", "username", user.Username, "error", err)
+	}
+}
+
+// verifyDummyPassword takes as long as checking the password of a user hashed by the
+// server's hasher, so that the response time of a login does not reveal whether the
+// username exists.
+func (server *AuthServer) verifyDummyPassword(password string) {
+	server.dummyMutex.Lock()
+	if server.dummyHash == "" {
+		server.dummyHash, _ = server.passwordHasher.Hash("dummy-password")
+	}
+	dummyHash := server.dummyHash
+	server.dummyMutex.Unlock()
+
+	if dummyHash != "" {
+		verifyPasswordHash(dummyHash, password)
+	}
+}
+
+func (server *AuthServer) findUser(username string) (*User, error) {
+	user, err := server.userStore.Find(username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user %s is not found", username)
+	}
+
+	return user, nil
+}
+
+// isValidRole reports whether users can have a role: a role of the policy, or one of
+// the built-in roles without a policy.
+func (server *AuthServer) isValidRole(role string) bool {
+	policy := server.policy.Load()
+	if policy == nil {
+		return IsValidRole(role)
+	}
+	return policy.HasRole(role)
+}
+
+// checkRoleGrant checks that the caller has all the permissions of a role, so that it
+// cannot give a user more permissions than its own, such as tenant:admin.
+func (server *AuthServer) checkRoleGrant(ctx context.Context, role string) error {
+	policy := server.policy.Load()
+	principal, ok := PrincipalFromContext(ctx)
+	if policy == nil || !ok || !policy.PrincipalHasPermissions(principal, policy.Roles[role]) {
+		return status.Errorf(codes.PermissionDenied, "no permission to grant the role %s", role)
+	}
+	return nil
+}
+
+// modifyTenantUser changes a user of the caller's tenant with the modify function,
+// atomically, so that the concurrent changes of the other fields are kept. Super admins
+// can only be changed by the callers with access to all the tenants.
+func (server *AuthServer) modifyTenantUser(ctx context.Context, username string, modify func(user *User) error) (*User, error) {
+	user, err := server.userStore.Modify(username, func(user *User) error {
+		if !inTenant(ctx, user.Tenant) || (user.Role == RoleSuperAdmin && !isCrossTenant(ctx)) {
+			return status.Errorf(codes.NotFound, "user %s is not found", username)
+		}
+		return modify(user)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			// the user is not in the caller's tenant
+			return nil, err
+		}
+		return nil, userUpdateError(err)
+	}
+
+	return user, nil
+}
+
+func userUpdateError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrConflict):
+		code = codes.Aborted
+	}
+	return status.Errorf(code, "cannot update user: %v", err)
+}
+
+func userTenant(user *User) string {
+	if user.Tenant == "" {
+		return DefaultTenant
+	}
+	return user.Tenant
+}
+
+func toPBUserAccount(user *User) *pb.UserAccount {
+	return &pb.UserAccount{
+		Username: user.Username,
+		Role:     user.Role,
+		Disabled: user.Disabled,
+		Tenant:   userTenant(user),
+	}
+}
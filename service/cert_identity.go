@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// A CertificateIdentity maps the client certificates matching one of its name fields
+// to a user and role. Exactly one of the name fields must be set.
+type CertificateIdentity struct {
+	// CommonName matches the common name of the certificate subject.
+	CommonName string `json:"common_name,omitempty"`
+	// DNSName, URI and Email match a subject alternative name of the certificate.
+	DNSName string `json:"dns_name,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	Email   string `json:"email,omitempty"`
+
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// Tenant is the tenant of the client, the DefaultTenant if empty.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// CertificateIdentities is the table mapping verified client certificates to principals,
+// so that machine clients can authenticate with mutual TLS only.
+type CertificateIdentities struct {
+	Identities []CertificateIdentity `json:"identities"`
+}
+
+// LoadCertificateIdentities reads a JSON table of certificate identities, whose tenants
+// must be among the known tenants.
+func LoadCertificateIdentities(filename string, tenants TenantSet) (*CertificateIdentities, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read certificate identities file: %w", err)
+	}
+
+	identities := &CertificateIdentities{}
+	err = json.Unmarshal(data, identities)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse certificate identities file %s: %w", filename, err)
+	}
+
+	for i, identity := range identities.Identities {
+		names := 0
+		for _, name := range []string{identity.CommonName, identity.DNSName, identity.URI, identity.Email} {
+			if name != "" {
+				names++
+			}
+		}
+
+		if names != 1 {
+			return nil, fmt.Errorf("certificate identity %d must match exactly one name", i)
+		}
+
+		if identity.Username == "" || identity.Role == "" {
+			return nil, fmt.Errorf("certificate identity %d must have a username and a role", i)
+		}
+
+		if identity.Tenant != "" {
+			err = ValidateTenant(identity.Tenant)
+			if err != nil {
+				return nil, fmt.Errorf("certificate identity %d: %w", i, err)
+			}
+			if !tenants[identity.Tenant] {
+				return nil, fmt.Errorf("certificate identity %d: unknown tenant %q", i, identity.Tenant)
+			}
+		}
+	}
+
+	return identities, nil
+}
+
+// Lookup returns the principal of the first identity matching the certificate.
+func (identities *CertificateIdentities) Lookup(cert *x509.Certificate) (*Principal, bool) {
+	for _, identity := range identities.Identities {
+		if identity.matches(cert) {
+			return &Principal{Username: identity.Username, Role: identity.Role, Tenant: identity.Tenant}, true
+		}
+	}
+	return nil, false
+}
+
+func (identity *CertificateIdentity) matches(cert *x509.Certificate) bool {
+	switch {
+	case identity.CommonName != "":
+		return cert.Subject.CommonName == identity.CommonName
+	case identity.DNSName != "":
+		return containsString(cert.DNSNames, identity.DNSName)
+	case identity.Email != "":
+		return containsString(cert.EmailAddresses, identity.Email)
+	case identity.URI != "":
+		for _, uri := range cert.URIs {
+			if uri.String() == identity.URI {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCertificate returns the client certificate of the connection, if it was
+// verified during the TLS handshake.
+func peerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0], true
+}
@@ -0,0 +1,108 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestCertificateIdentities(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "identities.json")
+	err := os.WriteFile(filename, []byte(`{
+		"identities": [
+			{"uri": "spiffe://pcbook/importer", "username": "importer", "role": "admin"},
+			{"dns_name": "reader.pcbclient.net", "username": "reader", "role": "user", "tenant": "eu"}
+		]
+	}`), 0600)
+	require.NoError(t, err)
+
+	tenants, err := service.NewTenantSet("eu")
+	require.NoError(t, err)
+
+	identities, err := service.LoadCertificateIdentities(filename, tenants)
+	require.NoError(t, err)
+
+	interceptor := service.NewAuthInterceptor(newTestJWTManager(t), service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+	interceptor.SetCertificateIdentities(identities)
+
+	importer := newTestCertificate(t, "importer", nil, "spiffe://pcbook/importer")
+	reader := newTestCertificate(t, "reader", []string{"reader.pcbclient.net"}, "")
+	unknown := newTestCertificate(t, "unknown", []string{"unknown.pcbclient.net"}, "")
+
+	principal, ok := identities.Lookup(importer)
+	require.True(t, ok)
+	require.Equal(t, "importer", principal.Username)
+
+	require.Equal(t, codes.OK, authorizeTestContext(interceptor, peerContext(importer, true), "/LaptopService/CreateLaptop"))
+	require.Equal(t, codes.OK, authorizeTestContext(interceptor, peerContext(reader, true), "/LaptopService/SearchLaptop"))
+	require.Equal(t, codes.PermissionDenied, authorizeTestContext(interceptor, peerContext(reader, true), "/LaptopService/CreateLaptop"))
+	require.Equal(t, codes.Unauthenticated, authorizeTestContext(interceptor, peerContext(unknown, true), "/LaptopService/SearchLaptop"))
+
+	// the certificate must have been verified by the TLS handshake
+	require.Equal(t, codes.Unauthenticated, authorizeTestContext(interceptor, peerContext(importer, false), "/LaptopService/CreateLaptop"))
+
+	for _, identity := range []string{
+		`{"username": "nobody", "role": "user"}`,
+		`{"common_name": "reader", "username": "reader", "role": "user", "tenant": "us"}`,
+		`{"common_name": "reader", "username": "reader", "role": "user", "tenant": "../eu"}`,
+	} {
+		err = os.WriteFile(filename, []byte(`{"identities": [`+identity+`]}`), 0600)
+		require.NoError(t, err)
+		_, err = service.LoadCertificateIdentities(filename, tenants)
+		require.Error(t, err, identity)
+	}
+}
+
+func newTestCertificate(t *testing.T, commonName string, dnsNames []string, uri string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		template.URIs = []*url.URL{u}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func peerContext(cert *x509.Certificate, verified bool) context.Context {
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchFiles calls onChange in the background when one of the files is created, removed
+// or modified, checking their modification time and size every interval, until the
+// context is done.
+func WatchFiles(ctx context.Context, interval time.Duration, files []string, onChange func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		states := fileStates(files)
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			current := fileStates(files)
+			changed := false
+			for i := range files {
+				if current[i] != states[i] {
+					changed = true
+				}
+			}
+
+			states = current
+			if changed {
+				onChange()
+			}
+		}
+	}()
+}
+
+// fileState identifies a version of a file, or its absence.
+type fileState struct {
+	exists  bool
+	modTime int64
+	size    int64
+}
+
+func fileStates(files []string) []fileState {
+	states := make([]fileState, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		states[i] = fileState{exists: true, modTime: info.ModTime().UnixNano(), size: info.Size()}
+	}
+	return states
+}
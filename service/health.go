@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// A HealthChecker can report whether it is available.
+type HealthChecker interface {
+	// Check returns an error if the checker is not available.
+	Check(ctx context.Context) error
+}
+
+// HealthCheckFunc is a function reporting whether a dependency is available.
+type HealthCheckFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f HealthCheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthMonitor sets the status of the gRPC health service: the services are serving
+// once the server is ready, as long as all the checks pass, and until it shuts down.
+type HealthMonitor struct {
+	server   *health.Server
+	services []string
+
+	mutex    sync.Mutex
+	checks   map[string]HealthChecker
+	ready    bool
+	shutdown bool
+	failures map[string]error
+}
+
+// NewHealthMonitor returns a new HealthMonitor setting the status of the services,
+// and of the server as a whole, in the health server. The services are not serving
+// until the monitor is set ready.
+func NewHealthMonitor(server *health.Server, services ...string) *HealthMonitor {
+	monitor := &HealthMonitor{
+		server:   server,
+		services: services,
+		checks:   make(map[string]HealthChecker),
+		failures: make(map[string]error),
+	}
+	monitor.update()
+	return monitor
+}
+
+// AddCheck adds a check run by Check.
+func (monitor *HealthMonitor) AddCheck(name string, check HealthChecker) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	monitor.checks[name] = check
+}
+
+// SetReady reports that the server completed its startup, or not.
+func (monitor *HealthMonitor) SetReady(ready bool) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	monitor.ready = ready
+	monitor.update()
+}
+
+// Shutdown reports that the server is shutting down. The services stay not serving.
+func (monitor *HealthMonitor) Shutdown() {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	monitor.shutdown = true
+	monitor.server.Shutdown()
+}
+
+// Check runs the checks, updates the status of the services, and returns the
+// error of the first check failing, by name.
+func (monitor *HealthMonitor) Check(ctx context.Context) error {
+	monitor.mutex.Lock()
+	checks := make(map[string]HealthChecker, len(monitor.checks))
+	for name, check := range monitor.checks {
+		checks[name] = check
+	}
+	monitor.mutex.Unlock()
+
+	failures := make(map[string]error)
+	for name, check := range checks {
+		err := check.Check(ctx)
+		if err != nil {
+			failures[name] = err
+		}
+	}
+
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	for name, err := range failures {
+		if monitor.failures[name] == nil {
+			slog.Warn("health check failed", "check", name, "error", err)
+		}
+	}
+	for name := range monitor.failures {
+		if failures[name] == nil {
+			slog.Info("health check recovered", "check", name)
+		}
+	}
+	monitor.failures = failures
+	monitor.update()
+
+	return firstFailure(failures)
+}
+
+// Watch runs the checks every interval in the background, until the context is done.
+func (monitor *HealthMonitor) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			monitor.Check(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// update sets the status of the services. It must be called with the mutex held.
+func (monitor *HealthMonitor) update() {
+	if monitor.shutdown {
+		return
+	}
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if monitor.ready && len(monitor.failures) == 0 {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	monitor.server.SetServingStatus("", status)
+	for _, service := range monitor.services {
+		monitor.server.SetServingStatus(service, status)
+	}
+}
+
+func firstFailure(failures map[string]error) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s: %w", names[0], failures[names[0]])
+}
+
+// checkFolder checks that files can be created in the folder.
+func checkFolder(folder string) error {
+	file, err := os.CreateTemp(folder, ".health-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to folder %s: %w", folder, err)
+	}
+
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/gateway"
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	monitor := service.NewHealthMonitor(healthServer, pb.LaptopService_ServiceDesc.ServiceName)
+
+	var storeDown atomic.Bool
+	monitor.AddCheck("store", service.HealthCheckFunc(func(ctx context.Context) error {
+		if storeDown.Load() {
+			return errors.New("store is down")
+		}
+		return nil
+	}))
+
+	// the health service is public, like the auth interceptor of the server allows it
+	interceptor := service.NewAuthInterceptor(newTestJWTManager(t), service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(interceptor.Unary()))
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	healthClient := healthpb.NewHealthClient(conn)
+
+	requireStatus := func(expected healthpb.HealthCheckResponse_ServingStatus, service string) {
+		t.Helper()
+		res, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, expected, res.GetStatus())
+	}
+
+	readyz := httptest.NewServer(gateway.ReadyzHandler(healthClient))
+	t.Cleanup(readyz.Close)
+	requireReadyz := func(expected int) {
+		t.Helper()
+		res, err := http.Get(readyz.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, expected, res.StatusCode)
+	}
+
+	// not serving until the startup completes
+	require.NoError(t, monitor.Check(context.Background()))
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING, "")
+	requireReadyz(http.StatusServiceUnavailable)
+
+	monitor.SetReady(true)
+	requireStatus(healthpb.HealthCheckResponse_SERVING, "")
+	requireStatus(healthpb.HealthCheckResponse_SERVING, pb.LaptopService_ServiceDesc.ServiceName)
+	requireReadyz(http.StatusOK)
+
+	storeDown.Store(true)
+	err = monitor.Check(context.Background())
+	require.ErrorContains(t, err, "store is down")
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING, "")
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING, pb.LaptopService_ServiceDesc.ServiceName)
+	requireReadyz(http.StatusServiceUnavailable)
+
+	storeDown.Store(false)
+	require.NoError(t, monitor.Check(context.Background()))
+	requireStatus(healthpb.HealthCheckResponse_SERVING, "")
+
+	// not serving anymore once the server starts shutting down
+	monitor.SetReady(false)
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING, "")
+	requireReadyz(http.StatusServiceUnavailable)
+
+	monitor.Shutdown()
+	require.NoError(t, monitor.Check(context.Background()))
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING, "")
+	requireReadyz(http.StatusServiceUnavailable)
+
+	healthz := httptest.NewRecorder()
+	gateway.HealthzHandler().ServeHTTP(healthz, httptest.NewRequest(http.MethodGet, gateway.HealthzPath, nil))
+	require.Equal(t, http.StatusOK, healthz.Code)
+}
+
+func TestLaptopServerCheck(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.Mkdir(imageFolder, 0o755))
+
+	imageStore := service.NewDiskImageStore(imageFolder)
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), imageStore, service.NewInMemoryRatingStore())
+	require.NoError(t, laptopServer.Check(context.Background()))
+
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, os.Remove(imageFolder))
+	err = laptopServer.Check(context.Background())
+	require.ErrorContains(t, err, "tenant default")
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"sync"
@@ -36,6 +37,11 @@ func NewDiskImageStore(imageFolder string) *DiskImageStore {
 	}
 }
 
+// Check checks that the images can be written to the image folder.
+func (store *DiskImageStore) Check(ctx context.Context) error {
+	return checkFolder(store.imageFolder)
+}
+
 // Save saves a new laptop image to the store.
 func (store *DiskImageStore) Save(
 	laptopID,
@@ -0,0 +1,113 @@
+package service_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/client"
+	"github.com/IkehAkinyemi/pcbook/gateway"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSVerifier(t *testing.T) {
+	t.Parallel()
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaPrivateKey, rsaPublicKey := newTestKeyPair(t)
+	ecdsaPrivateKey, ecdsaPublicKey := encodeTestKeyPair(t, ecdsaKey)
+	ed25519PrivateKey, ed25519PublicKey := encodeTestKeyPair(t, ed25519Key)
+
+	jwtManager, err := service.NewJWTManager(rsaPrivateKey, rsaPublicKey, 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	mux := runtime.NewServeMux()
+	err = mux.HandlePath(http.MethodGet, gateway.JWKSPath, gateway.JWKSHandler(jwtManager))
+	require.NoError(t, err)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	verifier := client.NewJWKSVerifier(server.URL+gateway.JWKSPath, time.Hour)
+	user := &service.User{Username: "alice", Role: service.RoleUser}
+
+	keys := []struct {
+		privateKey string
+		publicKey  string
+		algorithm  string
+	}{
+		{rsaPrivateKey, rsaPublicKey, "RS256"},
+		{ecdsaPrivateKey, ecdsaPublicKey, "ES256"},
+		{ed25519PrivateKey, ed25519PublicKey, "EdDSA"},
+	}
+
+	for i, key := range keys {
+		if i > 0 {
+			err := jwtManager.RotateSigningKey(key.privateKey, key.publicKey)
+			require.NoError(t, err)
+		}
+
+		keySet := jwtManager.JWKS()
+		require.Len(t, keySet.Keys, i+1)
+		for _, jwk := range keySet.Keys {
+			require.Equal(t, jwk.KeyID, jwk.Thumbprint())
+			if jwk.KeyID == jwtManager.SigningKeyID() {
+				require.Equal(t, key.algorithm, jwk.Algorithm)
+			}
+		}
+
+		token, err := jwtManager.GenerateToken(user)
+		require.NoError(t, err)
+
+		_, err = jwtManager.Verify(token)
+		require.NoError(t, err)
+
+		if i == 0 {
+			claims, err := verifier.Verify(token)
+			require.NoError(t, err)
+			require.Equal(t, "alice", claims.Username)
+		}
+	}
+
+	// the cached key set does not know the rotated keys yet
+	token, err := jwtManager.GenerateToken(user)
+	require.NoError(t, err)
+	_, err = verifier.Verify(token)
+	require.Error(t, err)
+
+	refreshToken, err := jwtManager.GenerateRefreshToken(user)
+	require.NoError(t, err)
+
+	verifier = client.NewJWKSVerifier(server.URL+gateway.JWKSPath, time.Hour)
+	claims, err := verifier.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, service.RoleUser, claims.Role)
+
+	_, err = verifier.Verify(refreshToken)
+	require.Error(t, err)
+}
+
+func encodeTestKeyPair(t *testing.T, privateKey crypto.Signer) (string, string) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	return string(privatePEM), string(publicPEM)
+}
@@ -1,48 +1,274 @@
 package service
 
 import (
+	"crypto"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// Token types, so that a refresh token cannot be used as an access token and vice versa.
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+)
+
+// Default values of the issuer and audience claims, and of the tolerated clock skew
+// between the server issuing a token and the one verifying it.
+const (
+	DefaultJWTIssuer   = "pcbook"
+	DefaultJWTAudience = "pcbook"
+	DefaultJWTLeeway   = 30 * time.Second
 )
 
 // JWTManager is a JSON web token manager.
+// Tokens are signed with a single signing key, using RS256, ES256 or EdDSA depending
+// on its type, and verified with the key whose ID matches the kid header of the token,
+// so that the signing key can be rotated without invalidating the tokens signed with
+// the previous one.
 type JWTManager struct {
-	privateKey    string
-	publicKey     string
-	tokenDuration time.Duration
+	tokenDuration   time.Duration
+	refreshDuration time.Duration
+	issuer          string
+	audience        string
+	leeway          time.Duration
+
+	mutex            sync.RWMutex
+	signingKey       crypto.Signer
+	signingKeyID     string
+	signingMethod    jwt.SigningMethod
+	verificationKeys map[string]*jwtKey
+	// previousKeyID is the ID of the key that signed the tokens before the signing key
+	previousKeyID string
+}
+
+// jwtKey is a parsed verification key.
+type jwtKey struct {
+	id     string
+	method jwt.SigningMethod
+	key    crypto.PublicKey
 }
 
 // UserClaims is a custom JWT claims that contains some user's information.
 type UserClaims struct {
 	jwt.StandardClaims
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Tenant    string `json:"tenant,omitempty"`
+	TokenType string `json:"token_type"`
 }
 
-// NewJWTManager returns an instance of JWT manager.
-func NewJWTManager(privateKey, publicKey string, tokenDuration time.Duration) *JWTManager {
-	return &JWTManager{privateKey, publicKey, tokenDuration}
+// NewJWTManager returns an instance of JWT manager signing tokens with the PEM-encoded
+// private key, and verifying them with the PEM-encoded public key. Access tokens are
+// valid for tokenDuration, and refresh tokens for refreshDuration.
+func NewJWTManager(privateKey, publicKey string, tokenDuration, refreshDuration time.Duration) (*JWTManager, error) {
+	manager := &JWTManager{
+		tokenDuration:    tokenDuration,
+		refreshDuration:  refreshDuration,
+		issuer:           DefaultJWTIssuer,
+		audience:         DefaultJWTAudience,
+		leeway:           DefaultJWTLeeway,
+		verificationKeys: make(map[string]*jwtKey),
+	}
+
+	err := manager.RotateSigningKey(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager, nil
 }
 
-// GenerateToken creates and signs a new token for a user.
-func (manager *JWTManager) GenerateToken(user *User) (string, error) {
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(manager.privateKey))
+// SetIssuer sets the issuer and audience claims of the tokens, which are checked on verification.
+func (manager *JWTManager) SetIssuer(issuer, audience string) {
+	manager.issuer = issuer
+	manager.audience = audience
+}
+
+// SetLeeway sets the clock skew tolerated when checking the time claims of a token.
+func (manager *JWTManager) SetLeeway(leeway time.Duration) {
+	manager.leeway = leeway
+}
+
+// RotateSigningKey signs the next tokens with a new key pair. The tokens signed with
+// the previous keys are still accepted until they expire.
+func (manager *JWTManager) RotateSigningKey(privateKey, publicKey string) error {
+	signingKey, key, err := parseJWTKeyPair(privateKey, publicKey)
+	if err != nil {
+		return err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if key.id != manager.signingKeyID {
+		manager.previousKeyID = manager.signingKeyID
+	}
+	manager.signingKey = signingKey
+	manager.signingKeyID = key.id
+	manager.signingMethod = key.method
+	manager.verificationKeys[key.id] = key
+	return nil
+}
+
+// parseJWTKeyPair parses a PEM-encoded key pair, and checks that the keys match.
+func parseJWTKeyPair(privateKey, publicKey string) (crypto.Signer, *jwtKey, error) {
+	signingKey, err := parseJWTPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := parseJWTPublicKey(publicKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse private key: %v", err)
+		return nil, nil, err
+	}
+
+	signingPublicKey, ok := signingKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !signingPublicKey.Equal(key.key) {
+		return nil, nil, fmt.Errorf("public key does not match private key")
+	}
+
+	return signingKey, key, nil
+}
+
+// AddVerificationKey accepts the tokens signed with the private key of a PEM-encoded public key.
+func (manager *JWTManager) AddVerificationKey(publicKey string) error {
+	key, err := parseJWTPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.verificationKeys[key.id] = key
+	return nil
+}
+
+// RemoveVerificationKey stops accepting the tokens signed with the key of the given ID.
+// The current signing key cannot be removed.
+func (manager *JWTManager) RemoveVerificationKey(keyID string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if keyID == manager.signingKeyID {
+		return fmt.Errorf("cannot remove the signing key")
+	}
+
+	if manager.verificationKeys[keyID] == nil {
+		return ErrNotFound
+	}
+
+	delete(manager.verificationKeys, keyID)
+	return nil
+}
+
+// ReplaceKeys replaces the key set at once: the next tokens are signed with the new key
+// pair, and verified with it, the PEM-encoded verification keys, and the key that signed
+// the tokens before it, so that these tokens stay valid until they expire. Nothing is
+// replaced if a key cannot be parsed.
+func (manager *JWTManager) ReplaceKeys(privateKey, publicKey string, verificationKeys ...string) error {
+	signingKey, key, err := parseJWTKeyPair(privateKey, publicKey)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*jwtKey{key.id: key}
+	for _, verificationKey := range verificationKeys {
+		key, err := parseJWTPublicKey(verificationKey)
+		if err != nil {
+			return err
+		}
+		keys[key.id] = key
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	previousKeyID := manager.previousKeyID
+	if key.id != manager.signingKeyID {
+		previousKeyID = manager.signingKeyID
+	}
+	if previousKey := manager.verificationKeys[previousKeyID]; previousKey != nil && keys[previousKeyID] == nil {
+		keys[previousKeyID] = previousKey
 	}
 
+	manager.previousKeyID = previousKeyID
+	manager.signingKey = signingKey
+	manager.signingKeyID = key.id
+	manager.signingMethod = key.method
+	manager.verificationKeys = keys
+	return nil
+}
+
+// SigningKeyID returns the ID of the key signing the tokens.
+func (manager *JWTManager) SigningKeyID() string {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	return manager.signingKeyID
+}
+
+// JWKS returns the verification keys as a JSON web key set, ordered by key ID.
+func (manager *JWTManager) JWKS() JSONWebKeySet {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	keySet := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range manager.verificationKeys {
+		jwk, err := NewJSONWebKey(key.key)
+		if err == nil {
+			keySet.Keys = append(keySet.Keys, jwk)
+		}
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool {
+		return keySet.Keys[i].KeyID < keySet.Keys[j].KeyID
+	})
+	return keySet
+}
+
+// GenerateToken creates and signs a new access token for a user.
+func (manager *JWTManager) GenerateToken(user *User) (string, error) {
+	return manager.generate(user, AccessTokenType, manager.tokenDuration)
+}
+
+// GenerateRefreshToken creates and signs a new refresh token for a user.
+func (manager *JWTManager) GenerateRefreshToken(user *User) (string, error) {
+	return manager.generate(user, RefreshTokenType, manager.refreshDuration)
+}
+
+func (manager *JWTManager) generate(user *User, tokenType string, duration time.Duration) (string, error) {
+	now := time.Now()
 	claims := UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(manager.tokenDuration).Unix(),
+			Id:        uuid.NewString(),
+			Issuer:    manager.issuer,
+			Audience:  manager.audience,
+			Subject:   user.Username,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
 		},
-		Username: user.Username,
-		Role:     user.Role,
+		Username:  user.Username,
+		Role:      user.Role,
+		Tenant:    user.Tenant,
+		TokenType: tokenType,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(privateKey)
+	manager.mutex.RLock()
+	signingKey := manager.signingKey
+	signingKeyID := manager.signingKeyID
+	signingMethod := manager.signingMethod
+	manager.mutex.RUnlock()
+
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = signingKeyID
+	signedToken, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %v", err)
 	}
@@ -50,23 +276,34 @@ func (manager *JWTManager) GenerateToken(user *User) (string, error) {
 	return signedToken, nil
 }
 
-// Verify verifies the given JWT token and returns the claims if the token is valid.
+// Verify verifies the given access token and returns the claims if the token is valid.
 func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(manager.publicKey))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %v", err)
-	}
+	return manager.verify(accessToken, AccessTokenType)
+}
+
+// VerifyRefreshToken verifies the given refresh token and returns the claims if the token is valid.
+func (manager *JWTManager) VerifyRefreshToken(refreshToken string) (*UserClaims, error) {
+	return manager.verify(refreshToken, RefreshTokenType)
+}
 
-	token, err := jwt.ParseWithClaims(
-		accessToken,
+func (manager *JWTManager) verify(signedToken string, tokenType string) (*UserClaims, error) {
+	// the time claims are checked below, with the leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	token, err := parser.ParseWithClaims(
+		signedToken,
 		&UserClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodRSA)
-			if !ok {
-				return nil, fmt.Errorf("unexpected token signing method")
+			key, err := manager.verificationKey(token)
+			if err != nil {
+				return nil, err
+			}
+
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected token signing method: %s", token.Method.Alg())
 			}
 
-			return publicKey, nil
+			return key.key, nil
 		},
 	)
 	if err != nil {
@@ -78,5 +315,67 @@ func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	err = claims.Validate(manager.issuer, manager.audience, manager.leeway, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("invalid token type: %q", claims.TokenType)
+	}
+
 	return claims, nil
 }
+
+// verificationKey returns the key identified by the kid header of the token.
+// Tokens without kid were signed before keys had IDs, with the current signing key.
+func (manager *JWTManager) verificationKey(token *jwt.Token) (*jwtKey, error) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		keyID = manager.signingKeyID
+	}
+
+	key := manager.verificationKeys[keyID]
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	return key, nil
+}
+
+// Validate checks the standard claims of a token issued by issuer for audience,
+// tolerating a clock skew of leeway.
+func (claims *UserClaims) Validate(issuer, audience string, leeway time.Duration, now time.Time) error {
+	if claims.Id == "" {
+		return fmt.Errorf("token has no ID")
+	}
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("token has no expiration time")
+	}
+
+	if now.Add(-leeway).Unix() > claims.ExpiresAt {
+		return fmt.Errorf("token is expired")
+	}
+
+	if now.Add(leeway).Unix() < claims.IssuedAt {
+		return fmt.Errorf("token is issued in the future")
+	}
+
+	if now.Add(leeway).Unix() < claims.NotBefore {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	if claims.Issuer != issuer {
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+
+	if claims.Audience != audience {
+		return fmt.Errorf("unexpected token audience %q", claims.Audience)
+	}
+
+	return nil
+}
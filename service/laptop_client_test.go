@@ -5,10 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/IkehAkinyemi/pcbook/pb"
 	"github.com/IkehAkinyemi/pcbook/sample"
@@ -16,7 +18,9 @@ import (
 	"github.com/IkehAkinyemi/pcbook/service"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestCreateLaptopClient(t *testing.T) {
@@ -114,6 +118,61 @@ func TestSearchLaptopClient(t *testing.T) {
 	require.Equal(t, len(expectedIDs), found)
 }
 
+func TestSearchLaptopSortedByRatingClient(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	// one perfect score against many slightly lower ones
+	perfect := sample.NewLaptop()
+	popular := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{perfect, popular} {
+		laptop.PriceUsd = 1000
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	_, err := ratingStore.Add(perfect.GetId(), 10)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := ratingStore.Add(popular.GetId(), 9)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	testCases := []struct {
+		sortBy      pb.SearchLaptopRequest_SortKey
+		expectedIDs []string
+	}{
+		{pb.SearchLaptopRequest_AVERAGE_SCORE, []string{perfect.GetId(), popular.GetId()}},
+		{pb.SearchLaptopRequest_BAYESIAN_SCORE, []string{popular.GetId(), perfect.GetId()}},
+		{pb.SearchLaptopRequest_SCORE_LOWER_BOUND, []string{popular.GetId(), perfect.GetId()}},
+	}
+
+	for _, tc := range testCases {
+		req := &pb.SearchLaptopRequest{
+			Filter: &pb.Filter{MaxPriceUsd: 1000},
+			SortBy: tc.sortBy,
+		}
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var ids []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			ids = append(ids, res.GetLaptop().GetId())
+		}
+		require.Equal(t, tc.expectedIDs, ids, tc.sortBy.String())
+	}
+}
+
 func TestUploadImageClient(t *testing.T) {
 	t.Parallel()
 
@@ -227,6 +286,256 @@ func TestRateLaptopClient(t *testing.T) {
 		require.Equal(t, laptop.GetId(), res.GetLaptopId())
 		require.Equal(t, uint32(idx+1), res.GetRatingCount())
 		require.Equal(t, average[idx], res.AverageScore)
+		require.InDelta(t, average[idx], res.DecayedScore, 1e-6)
+		require.Less(t, res.ScoreLowerBound, res.AverageScore)
+		require.Greater(t, res.ScoreUpperBound, res.AverageScore)
+	}
+}
+
+func TestRateLaptopInvalidScoreClient(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	requests := []*pb.RateLaptopRequest{
+		{LaptopId: laptop.GetId(), Score: math.NaN()},
+		{LaptopId: laptop.GetId(), Score: 11},
+		{LaptopId: laptop.GetId(), Score: -1},
+		{LaptopId: sample.NewLaptop().GetId(), Score: 5},
+		{LaptopId: laptop.GetId(), Score: 6},
+	}
+	expectedCodes := []codes.Code{codes.InvalidArgument, codes.InvalidArgument, codes.InvalidArgument, codes.NotFound, codes.OK}
+
+	for _, req := range requests {
+		err := stream.Send(req)
+		require.NoError(t, err)
+	}
+
+	err = stream.CloseSend()
+	require.NoError(t, err)
+
+	for idx := 0; ; idx++ {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			require.Equal(t, len(requests), idx)
+			return
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, requests[idx].GetLaptopId(), res.GetLaptopId())
+		require.Equal(t, expectedCodes[idx], codes.Code(res.GetError().GetCode()))
+		if expectedCodes[idx] == codes.OK {
+			require.Equal(t, uint32(1), res.GetRatingCount())
+			require.Equal(t, float64(6), res.GetAverageScore())
+		}
+	}
+}
+
+func TestWatchRatingsClient(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+
+	watched := sample.NewLaptop()
+	other := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{watched, other} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watchStream, err := laptopClient.WatchRatings(ctx, &pb.WatchRatingsRequest{LaptopIds: []string{watched.GetId()}})
+	require.NoError(t, err)
+
+	// wait for the subscription to be registered before rating
+	_, err = watchStream.Header()
+	require.NoError(t, err)
+
+	rateStream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	for _, req := range []*pb.RateLaptopRequest{
+		{LaptopId: other.GetId(), Score: 3},
+		{LaptopId: watched.GetId(), Score: 8},
+	} {
+		err := rateStream.Send(req)
+		require.NoError(t, err)
+		_, err = rateStream.Recv()
+		require.NoError(t, err)
+	}
+	require.NoError(t, rateStream.CloseSend())
+
+	res, err := watchStream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, watched.GetId(), res.GetLaptopId())
+	require.Equal(t, uint32(1), res.GetRatingCount())
+	require.Equal(t, float64(8), res.GetAverageScore())
+}
+
+func TestWatchLaptopsClient(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{})
+	require.NoError(t, err)
+
+	header, err := stream.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0"}, header.Get("last-sequence"))
+
+	laptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	laptop.PriceUsd = 999
+	_, err = laptopClient.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+
+	expectedTypes := []pb.LaptopEvent_Type{pb.LaptopEvent_CREATED, pb.LaptopEvent_UPDATED, pb.LaptopEvent_DELETED}
+	for i, eventType := range expectedTypes {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i+1), event.GetSequence())
+		require.Equal(t, eventType, event.GetType())
+		require.Equal(t, laptop.GetId(), event.GetLaptopId())
+	}
+
+	// resume after the first event
+	resumed, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{FromSequence: 1})
+	require.NoError(t, err)
+
+	for _, eventType := range expectedTypes[1:] {
+		event, err := resumed.Recv()
+		require.NoError(t, err)
+		require.Equal(t, eventType, event.GetType())
+	}
+
+	tmp, err := laptopStore.Find(laptop.GetId())
+	require.ErrorIs(t, err, service.ErrNotFound)
+	require.Nil(t, tmp)
+
+	// sequences from the future cannot be resumed
+	invalid, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{FromSequence: 10})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestLaptopServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	laptopsStream, err := laptopClient.WatchLaptops(context.Background(), &pb.WatchLaptopsRequest{})
+	require.NoError(t, err)
+	_, err = laptopsStream.Header()
+	require.NoError(t, err)
+
+	ratingsStream, err := laptopClient.WatchRatings(context.Background(), &pb.WatchRatingsRequest{})
+	require.NoError(t, err)
+	_, err = ratingsStream.Header()
+	require.NoError(t, err)
+
+	// the watch streams end on shutdown, so that the server drains without waiting
+	// for a timeout
+	laptopServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		grpcServer.Stop()
+		require.Fail(t, "the watch streams did not end on shutdown")
+	}
+
+	_, err = laptopsStream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = ratingsStream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestWatchCanceled(t *testing.T) {
+	t.Parallel()
+
+	// the status of the ended streams, as seen by the interceptors
+	results := make(chan error, 2)
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := handler(srv, stream)
+		results <- err
+		return err
+	}))
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	laptopsStream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{})
+	require.NoError(t, err)
+	_, err = laptopsStream.Header()
+	require.NoError(t, err)
+
+	ratingsStream, err := laptopClient.WatchRatings(ctx, &pb.WatchRatingsRequest{})
+	require.NoError(t, err)
+	_, err = ratingsStream.Header()
+	require.NoError(t, err)
+
+	// the canceled watches do not end with OK
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			require.Equal(t, codes.Canceled, status.Code(err))
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the watch streams did not end on cancel")
+		}
 	}
 }
 
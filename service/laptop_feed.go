@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrSequenceNotRetained is returned when a feed cannot be resumed from a sequence number
+// because its following events have already been discarded.
+var ErrSequenceNotRetained = errors.New("sequence is no longer retained")
+
+// DefaultLaptopFeedRetention is the number of events a LaptopFeed keeps for resuming watchers.
+const DefaultLaptopFeedRetention = 10000
+
+// A LaptopFeed is an ordered log of the changes to the laptop catalog.
+// Each event has a sequence number one greater than the previous event.
+type LaptopFeed struct {
+	mutex        sync.Mutex
+	events       []*pb.LaptopEvent
+	lastSequence uint64
+	retention    int
+	changed      chan struct{}
+}
+
+// NewLaptopFeed returns an empty LaptopFeed keeping the last retention events.
+func NewLaptopFeed(retention int) *LaptopFeed {
+	return &LaptopFeed{
+		retention: retention,
+		changed:   make(chan struct{}),
+	}
+}
+
+// LastSequence returns the sequence number of the last event, or zero if there is none.
+func (feed *LaptopFeed) LastSequence() uint64 {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	return feed.lastSequence
+}
+
+// Events returns the events following the given sequence number, and a channel
+// closed when a new event is appended after them.
+func (feed *LaptopFeed) Events(afterSequence uint64) ([]*pb.LaptopEvent, <-chan struct{}, error) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if afterSequence > feed.lastSequence {
+		return nil, nil, ErrSequenceNotRetained
+	}
+
+	firstSequence := feed.lastSequence - uint64(len(feed.events)) + 1
+	if afterSequence+1 < firstSequence {
+		return nil, nil, ErrSequenceNotRetained
+	}
+
+	events := feed.events[afterSequence+1-firstSequence:]
+	return events[:len(events):len(events)], feed.changed, nil
+}
+
+func (feed *LaptopFeed) append(tenant string, eventType pb.LaptopEvent_Type, laptopID string, laptop *pb.Laptop) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.lastSequence++
+	event := &pb.LaptopEvent{
+		Sequence: feed.lastSequence,
+		Type:     eventType,
+		LaptopId: laptopID,
+		Time:     timestamppb.Now(),
+		Tenant:   tenant,
+	}
+	if laptop != nil {
+		event.Laptop = proto.Clone(laptop).(*pb.Laptop)
+	}
+
+	feed.events = append(feed.events, event)
+	if len(feed.events) > feed.retention {
+		feed.events = feed.events[len(feed.events)-feed.retention:]
+	}
+
+	close(feed.changed)
+	feed.changed = make(chan struct{})
+}
+
+// A FeedLaptopStore records every change made through it in a LaptopFeed.
+type FeedLaptopStore struct {
+	LaptopStore
+	feed   *LaptopFeed
+	tenant string
+	mutex  sync.Mutex
+}
+
+// NewFeedLaptopStore returns a LaptopStore that saves the laptops to store and
+// appends the changes to feed, as changes of the DefaultTenant.
+func NewFeedLaptopStore(store LaptopStore, feed *LaptopFeed) *FeedLaptopStore {
+	return newTenantFeedLaptopStore(store, feed, DefaultTenant)
+}
+
+func newTenantFeedLaptopStore(store LaptopStore, feed *LaptopFeed, tenant string) *FeedLaptopStore {
+	return &FeedLaptopStore{
+		LaptopStore: store,
+		feed:        feed,
+		tenant:      tenant,
+	}
+}
+
+// Save saves the laptop to the store
+func (store *FeedLaptopStore) Save(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.LaptopStore.Save(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.feed.append(store.tenant, pb.LaptopEvent_CREATED, laptop.GetId(), laptop)
+	return nil
+}
+
+// Update replaces a laptop saved with the same ID
+func (store *FeedLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.LaptopStore.Update(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.feed.append(store.tenant, pb.LaptopEvent_UPDATED, laptop.GetId(), laptop)
+	return nil
+}
+
+// Delete deletes a laptop by ID
+func (store *FeedLaptopStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.LaptopStore.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	store.feed.append(store.tenant, pb.LaptopEvent_DELETED, id, nil)
+	return nil
+}
+
+// Check checks the underlying store, if it is a HealthChecker.
+func (store *FeedLaptopStore) Check(ctx context.Context) error {
+	checker, ok := store.LaptopStore.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return checker.Check(ctx)
+}
@@ -4,32 +4,144 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/IkehAkinyemi/pcbook/pb"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-// maximum 1 megabyte.
-const maxImageSize = 1 << 20
+// DefaultMaxImageSize is the maximum size of the uploaded images, 1 megabyte.
+const DefaultMaxImageSize = 1 << 20
 
-// A LaptopServer is the server that provides laptop services
+// A LaptopServer is the server that provides laptop services.
+// Each tenant has its own catalog of laptops, images and ratings, and the RPCs only
+// act on the catalog of the tenant selected for the caller.
 type LaptopServer struct {
 	pb.UnimplementedLaptopServiceServer
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	ratingStore RatingStore
+	catalogs     *tenantCatalogs
+	ratingScale  RatingScale
+	ratingPrior  RatingPrior
+	maxImageSize int
+	quotas       TenantQuotas
+	uploads      dailyUploads
+	laptopFeed   *LaptopFeed
+	webhooks     *WebhookDispatcher
+	metrics      *Metrics
+	// shutdown is closed when the server shuts down, to end the watch streams.
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
+// NewLaptopServer returns a new LaptopServer that rates laptops with the DefaultRatingScale.
+// The stores are the catalog of the DefaultTenant. The changes made to the catalogs through
+// the server are recorded in its LaptopFeed.
 func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
+	laptopFeed := NewLaptopFeed(DefaultLaptopFeedRetention)
+
+	catalogs := &tenantCatalogs{
+		catalogs: make(map[string]*tenantCatalog),
+		feed:     laptopFeed,
+		tenants:  TenantSet{DefaultTenant: true},
+	}
+	catalogs.add(DefaultTenant, laptopStore, imageStore, ratingStore)
+
 	return &LaptopServer{
-		laptopStore: laptopStore,
-		imageStore:  imageStore,
-		ratingStore: ratingStore,
+		catalogs:     catalogs,
+		laptopFeed:   laptopFeed,
+		ratingScale:  DefaultRatingScale,
+		ratingPrior:  DefaultRatingPrior(DefaultRatingScale),
+		maxImageSize: DefaultMaxImageSize,
+		shutdown:     make(chan struct{}),
+	}
+}
+
+// SetTenantStoreFactory sets the function creating the stores of the tenants other than
+// the DefaultTenant. Without it, the server only has the catalog of the DefaultTenant.
+func (server *LaptopServer) SetTenantStoreFactory(factory TenantStoreFactory) {
+	server.catalogs.mutex.Lock()
+	defer server.catalogs.mutex.Unlock()
+
+	server.catalogs.factory = factory
+}
+
+// SetTenants sets the tenants that can have a catalog. The RPCs of the callers of
+// other tenants fail without creating one.
+func (server *LaptopServer) SetTenants(tenants TenantSet) {
+	server.catalogs.mutex.Lock()
+	defer server.catalogs.mutex.Unlock()
+
+	server.catalogs.tenants = tenants
+}
+
+// LaptopFeed returns the feed of the changes made to the laptop store.
+func (server *LaptopServer) LaptopFeed() *LaptopFeed {
+	return server.laptopFeed
+}
+
+// SetWebhookDispatcher sets the dispatcher notified of uploaded images and added ratings.
+func (server *LaptopServer) SetWebhookDispatcher(dispatcher *WebhookDispatcher) {
+	server.webhooks = dispatcher
+}
+
+// SetMetrics sets the metrics recording the laptops, images and ratings added to the catalogs.
+func (server *LaptopServer) SetMetrics(metrics *Metrics) {
+	server.metrics = metrics
+}
+
+// SetRatingScale changes the scale that scores sent to RateLaptop are validated against.
+func (server *LaptopServer) SetRatingScale(scale RatingScale) {
+	server.ratingScale = scale
+}
+
+// SetRatingPrior changes the prior of the Bayesian average score.
+func (server *LaptopServer) SetRatingPrior(prior RatingPrior) {
+	server.ratingPrior = prior
+}
+
+// SetMaxImageSize changes the maximum size of the uploaded images, in bytes.
+func (server *LaptopServer) SetMaxImageSize(size int) {
+	server.maxImageSize = size
+}
+
+// SetQuotas sets the quotas of the catalog of each tenant.
+func (server *LaptopServer) SetQuotas(quotas TenantQuotas) {
+	server.quotas = quotas
+}
+
+// Shutdown ends the watch streams with Unavailable, and rejects the new ones, so that
+// the clients reconnect to another server. The watch streams never end by themselves,
+// so it must be called before the server is drained.
+func (server *LaptopServer) Shutdown() {
+	server.shutdownOnce.Do(func() {
+		close(server.shutdown)
+	})
+}
+
+// Check checks the stores of the catalogs that are HealthCheckers.
+func (server *LaptopServer) Check(ctx context.Context) error {
+	for _, catalog := range server.catalogs.all() {
+		for _, store := range []interface{}{catalog.laptopStore, catalog.imageStore, catalog.ratingStore} {
+			checker, ok := store.(HealthChecker)
+			if !ok {
+				continue
+			}
+
+			err := checker.Check(ctx)
+			if err != nil {
+				return fmt.Errorf("catalog of tenant %s: %w", catalog.tenant, err)
+			}
+		}
 	}
+	return nil
 }
 
 // CreateLaptop is controller for creating laptops.
@@ -39,7 +151,7 @@ func (server *LaptopServer) CreateLaptop(
 ) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
 
-	log.Printf("received laptop with id: %s", laptop.Id)
+	LoggerFromContext(ctx).Debug("received laptop", "laptop_id", laptop.GetId())
 
 	if len(laptop.Id) > 0 {
 		_, err := uuid.Parse(laptop.Id)
@@ -58,8 +170,31 @@ func (server *LaptopServer) CreateLaptop(
 		return nil, err
 	}
 
+	catalog, err := server.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if server.quotas.MaxLaptops > 0 {
+		catalog.createMutex.Lock()
+		defer catalog.createMutex.Unlock()
+
+		_, span := startSpan(ctx, "LaptopStore.Count", attribute.String("tenant", catalog.tenant))
+		count, err := catalog.laptopStore.Count()
+		endSpan(span, err)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot count laptops: %v", err)
+		}
+		if count >= server.quotas.MaxLaptops {
+			return nil, quotaExceeded("tenant:"+catalog.tenant,
+				fmt.Sprintf("tenant %s has reached its quota of %d laptops", catalog.tenant, server.quotas.MaxLaptops), 0)
+		}
+	}
+
 	// save the laptop to store
-	err := server.laptopStore.Save(laptop)
+	_, span := startSpan(ctx, "LaptopStore.Save", attribute.String("tenant", catalog.tenant))
+	err = catalog.laptopStore.Save(laptop)
+	endSpan(span, err)
 	if err != nil {
 		code := codes.Internal
 		if errors.Is(err, ErrAlreadyExists) {
@@ -67,7 +202,8 @@ func (server *LaptopServer) CreateLaptop(
 		}
 		return nil, status.Errorf(code, "cannot save laptop to the server: %v", err)
 	}
-	log.Printf("saved laptop with id: %s", laptop.Id)
+	LoggerFromContext(ctx).Info("saved laptop", "laptop_id", laptop.GetId())
+	server.metrics.laptopAdded(catalog.tenant)
 
 	res := &pb.CreateLaptopResponse{
 		Id: laptop.Id,
@@ -76,34 +212,154 @@ func (server *LaptopServer) CreateLaptop(
 	return res, nil
 }
 
+// UpdateLaptop is controller for replacing an existing laptop.
+func (server *LaptopServer) UpdateLaptop(
+	ctx context.Context,
+	req *pb.UpdateLaptopRequest,
+) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	LoggerFromContext(ctx).Debug("received laptop update", "laptop_id", laptop.GetId())
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	catalog, err := server.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(ctx, "LaptopStore.Update", attribute.String("tenant", catalog.tenant))
+	err = catalog.laptopStore.Update(laptop)
+	endSpan(span, err)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot update laptop: %v", err)
+	}
+	LoggerFromContext(ctx).Info("updated laptop", "laptop_id", laptop.GetId())
+
+	return &pb.UpdateLaptopResponse{}, nil
+}
+
+// DeleteLaptop is controller for deleting a laptop by ID.
+func (server *LaptopServer) DeleteLaptop(
+	ctx context.Context,
+	req *pb.DeleteLaptopRequest,
+) (*pb.DeleteLaptopResponse, error) {
+	LoggerFromContext(ctx).Debug("received laptop delete", "laptop_id", req.GetId())
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	catalog, err := server.catalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(ctx, "LaptopStore.Delete", attribute.String("tenant", catalog.tenant))
+	err = catalog.laptopStore.Delete(req.GetId())
+	endSpan(span, err)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot delete laptop: %v", err)
+	}
+	LoggerFromContext(ctx).Info("deleted laptop", "laptop_id", req.GetId())
+	server.metrics.laptopDeleted(catalog.tenant)
+
+	return &pb.DeleteLaptopResponse{}, nil
+}
+
 // SearchLaptop is controller for searching laptops by filter params.
+// The callers selecting all the tenants search every catalog, and each result has its tenant.
 func (server *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream pb.LaptopService_SearchLaptopServer,
 ) error {
 	filter := req.GetFilter()
-	log.Printf("receive a search-laptop request with filter: %v", filter)
+	sortBy := req.GetSortBy()
+	LoggerFromContext(stream.Context()).Debug("received search-laptop request", "filter", filter.String(), "sort_by", sortBy.String())
 
-	err := server.laptopStore.Search(
-		stream.Context(),
-		filter,
-		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{Laptop: laptop}
+	allTenants := TenantFromContext(stream.Context()) == AllTenants
 
-			err := stream.Send(res)
-			if err != nil {
-				return err
-			}
+	var catalogs []*tenantCatalog
+	if allTenants {
+		catalogs = server.catalogs.all()
+	} else {
+		catalog, err := server.catalog(stream.Context())
+		if err != nil {
+			return err
+		}
+		catalogs = []*tenantCatalog{catalog}
+	}
 
-			log.Printf("sent laptop with id: %s", laptop.GetId())
-			return nil
-		},
-	)
+	for _, catalog := range catalogs {
+		if sortBy != pb.SearchLaptopRequest_UNSORTED && catalog.ratingStore == nil {
+			return status.Errorf(codes.FailedPrecondition, "laptops cannot be sorted without a rating store")
+		}
+	}
 
+	var results []*pb.SearchLaptopResponse
+	for _, catalog := range catalogs {
+		catalog := catalog
+		ctx, span := startSpan(stream.Context(), "LaptopStore.Search", attribute.String("tenant", catalog.tenant))
+		err := catalog.laptopStore.Search(
+			ctx,
+			filter,
+			func(laptop *pb.Laptop) error {
+				res := &pb.SearchLaptopResponse{Laptop: laptop}
+				if allTenants {
+					res.Tenant = catalog.tenant
+				}
+				if catalog.ratingStore != nil {
+					_, span := startSpan(ctx, "RatingStore.Find")
+					rating, err := catalog.ratingStore.Find(laptop.GetId())
+					endSpan(span, err)
+					if err != nil {
+						return err
+					}
+					res.Rating = server.ratingResponse(laptop.GetId(), rating)
+				}
+
+				if sortBy != pb.SearchLaptopRequest_UNSORTED {
+					results = append(results, res)
+					return nil
+				}
+				return sendSearchResult(stream, res)
+			},
+		)
+		endSpan(span, err)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unexpected error: %v", err)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return ratingSortValue(results[i].GetRating(), sortBy) > ratingSortValue(results[j].GetRating(), sortBy)
+	})
+	for _, res := range results {
+		err := sendSearchResult(stream, res)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendSearchResult(stream pb.LaptopService_SearchLaptopServer, res *pb.SearchLaptopResponse) error {
+	err := stream.Send(res)
 	if err != nil {
-		return status.Errorf(codes.Internal, "unexpected error: %v", err)
+		return err
 	}
 
+	LoggerFromContext(stream.Context()).Debug("sent laptop", "laptop_id", res.GetLaptop().GetId())
 	return nil
 }
 
@@ -111,19 +367,26 @@ func (server *LaptopServer) SearchLaptop(
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive image info"))
+		return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot receive image info"))
 	}
 
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("receive an upload-image for laptop %s with image type %s", laptopID, imageType)
+	LoggerFromContext(stream.Context()).Debug("received upload-image request", "laptop_id", laptopID, "image_type", imageType)
 
-	laptop, err := server.laptopStore.Find(laptopID)
+	catalog, err := server.catalog(stream.Context())
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+		return logError(stream.Context(), err)
+	}
+
+	_, span := startSpan(stream.Context(), "LaptopStore.Find", attribute.String("tenant", catalog.tenant))
+	laptop, err := catalog.laptopStore.Find(laptopID)
+	endSpan(span, err)
+	if err != nil {
+		return logError(stream.Context(), status.Errorf(codes.Internal, "cannot find laptop: %v", err))
 	}
 	if laptop == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "laptop %s doesn't exist", laptopID))
+		return logError(stream.Context(), status.Errorf(codes.InvalidArgument, "laptop %s doesn't exist", laptopID))
 	}
 
 	imageData := bytes.Buffer{}
@@ -133,36 +396,51 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		if err := contextError(stream.Context()); err != nil {
 			return err
 		}
-		log.Print("waiting to receive more data")
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
 		}
 
 		chunk := req.GetChunkData()
 		size := len(chunk)
 
-		log.Printf("received a chunk with size: %d", size)
+		LoggerFromContext(stream.Context()).Debug("received image chunk", "size", size)
 
 		imageSize += size
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize))
+		if imageSize > server.maxImageSize {
+			return logError(stream.Context(), status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, server.maxImageSize))
 		}
 
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
+			return logError(stream.Context(), status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
+		}
+	}
+
+	uploadTime := time.Now()
+	if server.quotas.DailyUploadBytes > 0 {
+		retryDelay, ok := server.uploads.reserve(catalog.tenant, imageSize, server.quotas.DailyUploadBytes, uploadTime)
+		if !ok {
+			return logError(stream.Context(), quotaExceeded("tenant:"+catalog.tenant,
+				fmt.Sprintf("tenant %s has reached its quota of %d uploaded bytes per day", catalog.tenant, server.quotas.DailyUploadBytes), retryDelay))
 		}
 	}
 
-	imageID, err := server.imageStore.Save(laptopID, imageType, imageData)
+	_, span = startSpan(stream.Context(), "ImageStore.Save",
+		attribute.String("tenant", catalog.tenant),
+		attribute.Int("image.size", imageSize),
+	)
+	imageID, err := catalog.imageStore.Save(laptopID, imageType, imageData)
+	endSpan(span, err)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
+		if server.quotas.DailyUploadBytes > 0 {
+			server.uploads.release(catalog.tenant, imageSize, uploadTime)
+		}
+		return logError(stream.Context(), status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
 	}
 	res := &pb.UploadImageResponse{
 		Id:   imageID,
@@ -171,16 +449,35 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send response: %v", err))
 	}
 
-	log.Printf("saved image with id: %s, size: %d\n", imageID, imageSize)
+	LoggerFromContext(stream.Context()).Info("saved image", "image_id", imageID, "laptop_id", laptopID, "size", imageSize)
+	server.metrics.imageUploaded(catalog.tenant, imageSize)
+
+	server.webhooks.Publish(&pb.WebhookEvent{
+		Type:   pb.WebhookEvent_IMAGE_UPLOADED,
+		Tenant: catalog.tenant,
+		Payload: &pb.WebhookEvent_Image{
+			Image: &pb.UploadedImage{
+				Id:        imageID,
+				LaptopId:  laptopID,
+				ImageType: imageType,
+				Size:      uint32(imageSize),
+			},
+		},
+	})
 	return nil
 }
 
 // RateLaptop is a bidirectional-streaming RPC that allows client to rate a stream of laptops
 // with a score, and returns a stream of average score for each of them.
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	catalog, err := server.catalog(stream.Context())
+	if err != nil {
+		return logError(stream.Context(), err)
+	}
+
 	for {
 		if err := contextError(stream.Context()); err != nil {
 			return err
@@ -188,49 +485,205 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive stream request: %v", err))
+			return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot receive stream request: %v", err))
 		}
 
 		laptopID := req.GetLaptopId()
 		score := req.GetScore()
+		LoggerFromContext(stream.Context()).Debug("received rate-laptop request", "laptop_id", laptopID, "score", score)
 
-		log.Printf("received a rate-laptop request: id = %s, score = %.2f", laptopID, score)
-
-		found, err := server.laptopStore.Find(laptopID)
+		res, err := server.rateLaptop(stream.Context(), catalog, laptopID, score)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+			st, _ := status.FromError(err)
+			if st.Code() == codes.Internal {
+				return logError(stream.Context(), err)
+			}
+			logError(stream.Context(), err)
+			res = &pb.RateLaptopResponse{
+				LaptopId: laptopID,
+				Error:    st.Proto(),
+			}
 		}
-		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptop %s is not found", laptopID))
+
+		err = stream.Send(res)
+		if err != nil {
+			return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
 		}
+	}
+
+	return nil
+}
+
+// WatchRatings is a server-streaming RPC that sends the new rating of the watched laptops
+// every time they are rated.
+func (server *LaptopServer) WatchRatings(
+	req *pb.WatchRatingsRequest,
+	stream pb.LaptopService_WatchRatingsServer,
+) error {
+	LoggerFromContext(stream.Context()).Debug("received watch-ratings request", "laptop_ids", req.GetLaptopIds())
+
+	catalog, err := server.catalog(stream.Context())
+	if err != nil {
+		return logError(stream.Context(), err)
+	}
+
+	ctx, cancel := server.watchContext(stream.Context())
+	defer cancel()
+
+	subscription := catalog.ratingHub.Subscribe(req.GetLaptopIds())
+	defer subscription.Close()
 
-		rating, err := server.ratingStore.Add(laptopID, score)
+	// let the client know that the updates are watched from now on
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send header: %v", err))
+	}
+
+	for {
+		rating, err := subscription.Next(ctx)
+		if errors.Is(err, ErrSubscriberTooSlow) {
+			return logError(stream.Context(), status.Errorf(codes.ResourceExhausted, "cannot keep up with rating updates: %v", err))
+		}
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot add rating to the store: %v", err))
+			if stream.Context().Err() == nil {
+				return errShuttingDown
+			}
+			return logError(stream.Context(), status.FromContextError(stream.Context().Err()).Err())
 		}
 
-		res := &pb.RateLaptopResponse{
-			LaptopId:     laptopID,
-			RatingCount:  rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+		err = stream.Send(rating)
+		if err != nil {
+			return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send rating update: %v", err))
 		}
+	}
+}
 
-		err = stream.Send(res)
+// WatchLaptops is a server-streaming RPC that sends the changes made to the laptop catalog
+// of the caller's tenant in order. The stream can be resumed after the last received event
+// with its sequence number.
+func (server *LaptopServer) WatchLaptops(
+	req *pb.WatchLaptopsRequest,
+	stream pb.LaptopService_WatchLaptopsServer,
+) error {
+	sequence := req.GetFromSequence()
+	if sequence == 0 {
+		sequence = server.laptopFeed.LastSequence()
+	}
+	LoggerFromContext(stream.Context()).Debug("received watch-laptops request", "sequence", sequence)
+
+	// let the client know from which sequence the changes are watched
+	header := metadata.Pairs("last-sequence", strconv.FormatUint(sequence, 10))
+	err := stream.SendHeader(header)
+	if err != nil {
+		return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send header: %v", err))
+	}
+
+	for {
+		events, changed, err := server.laptopFeed.Events(sequence)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
+			return logError(stream.Context(), status.Errorf(codes.OutOfRange, "cannot resume from sequence %d: %v", sequence, err))
+		}
+
+		for _, event := range events {
+			sequence = event.GetSequence()
+			if !inTenant(stream.Context(), event.GetTenant()) {
+				continue
+			}
+
+			err := stream.Send(event)
+			if err != nil {
+				return logError(stream.Context(), status.Errorf(codes.Unknown, "cannot send laptop event: %v", err))
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-server.shutdown:
+			return errShuttingDown
+		case <-stream.Context().Done():
+			return logError(stream.Context(), status.FromContextError(stream.Context().Err()).Err())
 		}
 	}
+}
 
-	return nil
+// rateLaptop adds a valid score to the rating store of the catalog and returns the laptop's new rating.
+func (server *LaptopServer) rateLaptop(
+	ctx context.Context,
+	catalog *tenantCatalog,
+	laptopID string,
+	score float64,
+) (*pb.RateLaptopResponse, error) {
+	err := server.ratingScale.Validate(score)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	_, span := startSpan(ctx, "LaptopStore.Find", attribute.String("tenant", catalog.tenant))
+	_, err = catalog.laptopStore.Find(laptopID)
+	endSpan(span, err)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "laptop %s is not found", laptopID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+
+	_, span = startSpan(ctx, "RatingStore.Add", attribute.String("tenant", catalog.tenant))
+	rating, err := catalog.ratingStore.Add(laptopID, score)
+	endSpan(span, err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot add rating to the store: %v", err)
+	}
+
+	server.metrics.ratingAdded(catalog.tenant)
+
+	res := server.ratingResponse(laptopID, rating)
+	catalog.ratingHub.Publish(res)
+	server.webhooks.Publish(&pb.WebhookEvent{
+		Type:    pb.WebhookEvent_RATING_ADDED,
+		Tenant:  catalog.tenant,
+		Payload: &pb.WebhookEvent_Rating{Rating: res},
+	})
+	return res, nil
+}
+
+// errShuttingDown ends the watch streams when the server shuts down.
+var errShuttingDown = status.Errorf(codes.Unavailable, "server is shutting down")
+
+// watchContext returns a copy of ctx that is canceled when the server shuts down.
+func (server *LaptopServer) watchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-server.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// catalog returns the catalog of the tenant selected for the caller.
+func (server *LaptopServer) catalog(ctx context.Context) (*tenantCatalog, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant == AllTenants {
+		return nil, status.Errorf(codes.InvalidArgument, "this RPC cannot be called on all tenants")
+	}
+
+	catalog, err := server.catalogs.get(tenant)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+
+	return catalog, nil
 }
 
-func logError(err error) error {
+func logError(ctx context.Context, err error) error {
 	if err != nil {
-		log.Print(err)
+		LoggerFromContext(ctx).Debug("call failed", "error", err)
 	}
 
 	return err
@@ -239,9 +692,9 @@ func logError(err error) error {
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		logError(status.Error(codes.Canceled, "request is canceled"))
+		logError(ctx, status.Error(codes.Canceled, "request is canceled"))
 	case context.DeadlineExceeded:
-		logError(status.Error(codes.DeadlineExceeded, "request deadline exceeded"))
+		logError(ctx, status.Error(codes.DeadlineExceeded, "request deadline exceeded"))
 	default:
 		return nil
 	}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"sync"
 
 	"github.com/IkehAkinyemi/pcbook/pb"
@@ -19,10 +18,16 @@ var ErrNotFound = errors.New("record not found")
 type LaptopStore interface {
 	// Save saves the laptop to the store
 	Save(laptop *pb.Laptop) error
+	// Update replaces a laptop saved with the same ID
+	Update(laptop *pb.Laptop) error
+	// Delete deletes a laptop by ID
+	Delete(id string) error
 	// Find finds a laptop by ID
 	Find(id string) (*pb.Laptop, error)
 	// Search searches for laptops with filter, returns one by one via the found function
 	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// Count returns the number of laptops in the store
+	Count() (int, error)
 }
 
 // A InMemoryLaptopStore stores laptop in memory.
@@ -57,6 +62,37 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	return nil
 }
 
+// Update replaces a laptop saved with the same ID
+func (store *InMemoryLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.data[laptop.Id] == nil {
+		return ErrNotFound
+	}
+
+	copy, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.data[copy.Id] = copy
+	return nil
+}
+
+// Delete deletes a laptop by ID
+func (store *InMemoryLaptopStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.data[id] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.data, id)
+	return nil
+}
+
 // Find finds a laptop by ID
 func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	store.mutex.RLock()
@@ -70,6 +106,14 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return deepCopy(laptop)
 }
 
+// Count returns the number of laptops in the store
+func (store *InMemoryLaptopStore) Count() (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.data), nil
+}
+
 // Search returns laptops that match the search criteria in filter.
 func (store *InMemoryLaptopStore) Search(
 	ctx context.Context,
@@ -81,11 +125,12 @@ func (store *InMemoryLaptopStore) Search(
 
 	for _, laptop := range store.data {
 		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
-			log.Printf("context is cancelled")
 			return errors.New("context is cancelled")
 		}
 		if isQualified(filter, laptop) {
+			_, span := startSpan(ctx, "deepCopy")
 			copy, err := deepCopy(laptop)
+			endSpan(span, err)
 			if err != nil {
 				return err
 			}
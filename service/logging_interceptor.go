@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata carrying the ID of a request. The server keeps
+// the ID sent by the client, or assigns one, and returns it in the response header.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength is the length of the longest request ID accepted from a client.
+const maxRequestIDLength = 128
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx that carries the logger.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger stored in ctx by the LoggingInterceptor,
+// or the default logger.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
+
+// LoggingInterceptor is a server interceptor assigning a request ID to every RPC,
+// and logging its method, peer, principal, status code and duration. It must run
+// before the other interceptors, so that their logs carry the request ID.
+type LoggingInterceptor struct {
+	logger *slog.Logger
+}
+
+// NewLoggingInterceptor returns a new LoggingInterceptor writing to logger.
+func NewLoggingInterceptor(logger *slog.Logger) *LoggingInterceptor {
+	return &LoggingInterceptor{
+		logger: logger,
+	}
+}
+
+// Unary returns a server interceptor function to log unary RPC.
+func (interceptor *LoggingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		requestID := requestIDFromContext(ctx)
+		err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, requestID))
+		if err != nil {
+			interceptor.logger.Warn("cannot send request id", "error", err)
+		}
+
+		logger := interceptor.logger.With("request_id", requestID)
+		ctx, recorder := contextWithPrincipalRecorder(ContextWithLogger(ctx, logger))
+		res, err := handler(ctx, req)
+
+		interceptor.log(ctx, logger, "unary", info.FullMethod, start, recorder.principal, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to log stream RPC.
+func (interceptor *LoggingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		requestID := requestIDFromContext(ss.Context())
+		err := ss.SetHeader(metadata.Pairs(RequestIDHeader, requestID))
+		if err != nil {
+			interceptor.logger.Warn("cannot send request id", "error", err)
+		}
+
+		logger := interceptor.logger.With("request_id", requestID)
+		ctx, recorder := contextWithPrincipalRecorder(ContextWithLogger(ss.Context(), logger))
+		err = handler(srv, &serverStreamWithContext{ss, ctx})
+
+		interceptor.log(ctx, logger, "stream", info.FullMethod, start, recorder.principal, err)
+		return err
+	}
+}
+
+func (interceptor *LoggingInterceptor) log(
+	ctx context.Context,
+	logger *slog.Logger,
+	kind string,
+	method string,
+	start time.Time,
+	principal *Principal,
+	err error,
+) {
+	code := status.Code(err)
+	attrs := []slog.Attr{
+		slog.String("kind", kind),
+		slog.String("method", method),
+		slog.String("peer", peerIP(ctx)),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if principal != nil {
+		attrs = append(attrs, slog.String("username", principal.Username))
+		if principal.APIKeyID != "" {
+			attrs = append(attrs, slog.String("api_key_id", principal.APIKeyID))
+		}
+		if principal.Tenant != "" {
+			attrs = append(attrs, slog.String("tenant", principal.Tenant))
+		}
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+
+	logger.LogAttrs(ctx, codeLevel(code), "finished call", attrs...)
+}
+
+// codeLevel returns the level of the logs of the calls ending with code: errors for
+// the server faults, warnings for the calls rejected because of the client.
+func codeLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK, codes.Canceled:
+		return slog.LevelInfo
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal,
+		codes.Unavailable, codes.DataLoss:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// requestIDFromContext returns the request ID sent by the client, or a new one when
+// the client sent none or an invalid one.
+func requestIDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values := md.Get(RequestIDHeader)
+		if len(values) > 0 && isValidRequestID(values[0]) {
+			return values[0]
+		}
+	}
+	return uuid.NewString()
+}
+
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range requestID {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// logBuffer is a bytes.Buffer safe for concurrent use by the server and the test.
+type logBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *logBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+// records returns the logs with the given message, decoded from JSON.
+func (buffer *logBuffer) records(t *testing.T, msg string) []map[string]interface{} {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(buffer.buffer.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	t.Parallel()
+
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	jwtManager := newTestJWTManager(t)
+	loggingInterceptor := service.NewLoggingInterceptor(logger)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(loggingInterceptor.Unary(), authInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(loggingInterceptor.Stream(), authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	token, err := jwtManager.GenerateToken(&service.User{Username: "admin1", Role: service.RoleAdmin})
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", token, service.RequestIDHeader, "request-1")
+
+	// the request ID sent by the client is kept
+	laptop := sample.NewLaptop()
+	var header metadata.MD
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"request-1"}, header.Get(service.RequestIDHeader))
+
+	calls := logs.records(t, "finished call")
+	require.Len(t, calls, 1)
+	require.Equal(t, "request-1", calls[0]["request_id"])
+	require.Equal(t, "unary", calls[0]["kind"])
+	require.Equal(t, "/LaptopService/CreateLaptop", calls[0]["method"])
+	require.Equal(t, "admin1", calls[0]["username"])
+	require.Equal(t, codes.OK.String(), calls[0]["code"])
+	require.Equal(t, "INFO", calls[0]["level"])
+	require.Contains(t, calls[0], "duration")
+	require.NotEmpty(t, calls[0]["peer"])
+
+	// the logs of the server carry the request ID
+	saved := logs.records(t, "saved laptop")
+	require.Len(t, saved, 1)
+	require.Equal(t, "request-1", saved[0]["request_id"])
+	require.Equal(t, laptop.GetId(), saved[0]["laptop_id"])
+
+	// a request ID is assigned to the streams without one
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{}})
+	require.NoError(t, err)
+	header, err = stream.Header()
+	require.NoError(t, err)
+	requestID := header.Get(service.RequestIDHeader)
+	require.Len(t, requestID, 1)
+	require.NotEmpty(t, requestID[0])
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	calls = logs.records(t, "finished call")
+	require.Len(t, calls, 2)
+	require.Equal(t, requestID[0], calls[1]["request_id"])
+	require.Equal(t, "stream", calls[1]["kind"])
+	require.Equal(t, codes.Unauthenticated.String(), calls[1]["code"])
+	require.Equal(t, "WARN", calls[1]["level"])
+	require.NotContains(t, calls[1], "username")
+}
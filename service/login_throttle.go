@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// maxFailedLogins is the number of failed logins kept by a LoginThrottle.
+const maxFailedLogins = 1000
+
+// loginThrottleSweepInterval is the interval at which the forgotten failures are dropped.
+const loginThrottleSweepInterval = time.Minute
+
+// LoginThrottleOptions configures the lockout of the usernames and IP addresses
+// with too many failed logins.
+type LoginThrottleOptions struct {
+	// MaxUserFailures and MaxIPFailures are the number of failed logins allowed
+	// before a username or an IP address is locked out.
+	MaxUserFailures int
+	MaxIPFailures   int
+	// BaseLockout is the duration of the first lockout, doubled after every
+	// further failure up to MaxLockout.
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// ResetAfter is the duration after which the failures are forgotten.
+	ResetAfter time.Duration
+}
+
+// DefaultLoginThrottleOptions locks a username out after 5 failed logins, and an
+// IP address after 20, for 30 seconds at first and up to 15 minutes.
+var DefaultLoginThrottleOptions = LoginThrottleOptions{
+	MaxUserFailures: 5,
+	MaxIPFailures:   20,
+	BaseLockout:     30 * time.Second,
+	MaxLockout:      15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// A FailedLogin is an entry of the audit trail of failed logins.
+type FailedLogin struct {
+	Username string
+	IP       string
+	Reason   string
+	Time     time.Time
+}
+
+// loginFailures counts the consecutive failed logins of a username or an IP address.
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// A LoginThrottle locks out the usernames and IP addresses with too many failed
+// logins, with an exponential lockout, and keeps an audit trail of the failures.
+type LoginThrottle struct {
+	options LoginThrottleOptions
+
+	mutex        sync.Mutex
+	users        map[string]*loginFailures
+	ips          map[string]*loginFailures
+	failedLogins []FailedLogin
+	lastSweep    time.Time
+}
+
+// NewLoginThrottle returns a new LoginThrottle.
+func NewLoginThrottle(options LoginThrottleOptions) *LoginThrottle {
+	return &LoginThrottle{
+		options: options,
+		users:   make(map[string]*loginFailures),
+		ips:     make(map[string]*loginFailures),
+	}
+}
+
+// Allow returns whether a login for username from ip can be attempted at time now,
+// or else how long until the lockout ends.
+func (throttle *LoginThrottle) Allow(username, ip string, now time.Time) (time.Duration, bool) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	var retryAfter time.Duration
+	for _, failures := range []*loginFailures{throttle.users[username], throttle.ips[ip]} {
+		if failures != nil && now.Before(failures.lockedUntil) {
+			if wait := failures.lockedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	return retryAfter, retryAfter == 0
+}
+
+// Fail records a failed login for username from ip at time now.
+func (throttle *LoginThrottle) Fail(username, ip, reason string, now time.Time) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	throttle.sweep(now)
+	throttle.fail(throttle.users, username, throttle.options.MaxUserFailures, now)
+	if ip != "" {
+		throttle.fail(throttle.ips, ip, throttle.options.MaxIPFailures, now)
+	}
+
+	throttle.failedLogins = append(throttle.failedLogins, FailedLogin{
+		Username: username,
+		IP:       ip,
+		Reason:   reason,
+		Time:     now,
+	})
+	if len(throttle.failedLogins) > maxFailedLogins {
+		throttle.failedLogins = throttle.failedLogins[len(throttle.failedLogins)-maxFailedLogins:]
+	}
+
+	slog.Warn("failed login", "username", username, "ip", ip, "reason", reason)
+}
+
+func (throttle *LoginThrottle) fail(entries map[string]*loginFailures, key string, maxFailures int, now time.Time) {
+	failures := entries[key]
+	if failures == nil || now.Sub(failures.lastFailure) > throttle.options.ResetAfter {
+		failures = &loginFailures{}
+		entries[key] = failures
+	}
+
+	failures.count++
+	failures.lastFailure = now
+
+	if failures.count >= maxFailures {
+		lockout := throttle.options.BaseLockout
+		for i := maxFailures; i < failures.count && lockout < throttle.options.MaxLockout; i++ {
+			lockout *= 2
+		}
+		if lockout > throttle.options.MaxLockout {
+			lockout = throttle.options.MaxLockout
+		}
+		failures.lockedUntil = now.Add(lockout)
+	}
+}
+
+// sweep drops the failures that are forgotten and no longer locked out, at most once
+// every loginThrottleSweepInterval, so that a failed login does not scan them all.
+func (throttle *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(throttle.lastSweep) < loginThrottleSweepInterval {
+		return
+	}
+	throttle.lastSweep = now
+
+	for _, entries := range []map[string]*loginFailures{throttle.users, throttle.ips} {
+		for key, failures := range entries {
+			if now.Sub(failures.lastFailure) > throttle.options.ResetAfter && now.After(failures.lockedUntil) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// Succeed forgets the failed logins of a username after a successful login.
+func (throttle *LoginThrottle) Succeed(username string) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	delete(throttle.users, username)
+}
+
+// FailedLogins returns the audit trail of failed logins, optionally only those of a username.
+func (throttle *LoginThrottle) FailedLogins(username string) []FailedLogin {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	var failedLogins []FailedLogin
+	for _, failedLogin := range throttle.failedLogins {
+		if username == "" || failedLogin.Username == username {
+			failedLogins = append(failedLogins, failedLogin)
+		}
+	}
+	return failedLogins
+}
+
+// peerIP returns the IP address of the caller. Requests forwarded by a REST gateway
+// on the same host are attributed to the last address of their x-forwarded-for header.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		md, _ := metadata.FromIncomingContext(ctx)
+		forwarded := md.Get("x-forwarded-for")
+		if len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			forwardedIP := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1]))
+			if forwardedIP != nil {
+				return forwardedIP.String()
+			}
+		}
+	}
+
+	return host
+}
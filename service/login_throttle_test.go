@@ -0,0 +1,100 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	t.Parallel()
+
+	throttle := service.NewLoginThrottle(service.LoginThrottleOptions{
+		MaxUserFailures: 3,
+		MaxIPFailures:   5,
+		BaseLockout:     time.Minute,
+		MaxLockout:      3 * time.Minute,
+		ResetAfter:      time.Hour,
+	})
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		throttle.Fail("alice", "10.0.0.1", "incorrect password", now)
+	}
+	_, ok := throttle.Allow("alice", "10.0.0.1", now)
+	require.True(t, ok)
+
+	// the lockout doubles with every failure, up to the maximum
+	for _, lockout := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		throttle.Fail("alice", "10.0.0.2", "incorrect password", now)
+		retryAfter, ok := throttle.Allow("alice", "10.0.0.3", now)
+		require.False(t, ok)
+		require.Equal(t, lockout, retryAfter)
+	}
+
+	_, ok = throttle.Allow("bob", "10.0.0.3", now)
+	require.True(t, ok)
+
+	// the IP address is locked out after failures for any username
+	for i := 0; i < 5; i++ {
+		throttle.Fail("user", "10.0.0.4", "unknown user", now)
+	}
+	_, ok = throttle.Allow("bob", "10.0.0.4", now)
+	require.False(t, ok)
+
+	_, ok = throttle.Allow("alice", "10.0.0.3", now.Add(3*time.Minute))
+	require.True(t, ok)
+
+	throttle.Succeed("alice")
+	require.Len(t, throttle.FailedLogins("alice"), 5)
+	require.Len(t, throttle.FailedLogins(""), 10)
+}
+
+func TestLoginBruteForceServer(t *testing.T) {
+	t.Parallel()
+
+	authServer := service.NewAuthServer(service.NewInMemoryUserStore(), newTestJWTManager(t), service.NewInMemoryRevocationStore())
+	authServer.SetLoginThrottle(service.NewLoginThrottle(service.LoginThrottleOptions{
+		MaxUserFailures: 3,
+		MaxIPFailures:   100,
+		BaseLockout:     time.Minute,
+		MaxLockout:      time.Hour,
+		ResetAfter:      time.Hour,
+	}))
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242},
+	})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "198.51.100.7"))
+
+	_, err := authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "alice-secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	for i := 0; i < 3; i++ {
+		_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong"})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	// alice is locked out, even with her password
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	failedLogins, err := authServer.ListFailedLogins(ctx, &pb.ListFailedLoginsRequest{Username: "alice"})
+	require.NoError(t, err)
+	require.Len(t, failedLogins.GetFailedLogins(), 4)
+	require.Equal(t, "198.51.100.7", failedLogins.GetFailedLogins()[0].GetIp())
+	require.Equal(t, "incorrect password", failedLogins.GetFailedLogins()[0].GetReason())
+	require.Equal(t, "locked out", failedLogins.GetFailedLogins()[3].GetReason())
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MethodPolicies returns the access rules declared by the auth option of the RPCs
+// in the files, keyed by full method name. RPCs without the option are left out.
+func MethodPolicies(files *protoregistry.Files) map[string]MethodPolicy {
+	rules := make(map[string]MethodPolicy)
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+
+				options, ok := method.Options().(*descriptorpb.MethodOptions)
+				if !ok || !proto.HasExtension(options, pb.E_Auth) {
+					continue
+				}
+
+				auth := proto.GetExtension(options, pb.E_Auth).(*pb.MethodAuth)
+				rule := MethodPolicy{Public: auth.GetPublic()}
+				for _, permission := range auth.GetPermissions() {
+					rule.Permissions = append(rule.Permissions, Permission(permission))
+				}
+
+				rules[fullMethodName(method)] = rule
+			}
+		}
+		return true
+	})
+
+	return rules
+}
+
+// AuditedMethods returns the full names of the RPCs whose auth option sets audit.
+func AuditedMethods(files *protoregistry.Files) map[string]bool {
+	audited := make(map[string]bool)
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+
+				options, ok := method.Options().(*descriptorpb.MethodOptions)
+				if !ok || !proto.HasExtension(options, pb.E_Auth) {
+					continue
+				}
+
+				auth := proto.GetExtension(options, pb.E_Auth).(*pb.MethodAuth)
+				if auth.GetAudit() {
+					audited[fullMethodName(method)] = true
+				}
+			}
+		}
+		return true
+	})
+
+	return audited
+}
+
+// fullMethodName returns the name of an RPC as seen by the interceptors.
+func fullMethodName(method protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+}
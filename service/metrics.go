@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics holds the Prometheus metrics of the gRPC server: the calls of every RPC,
+// and the laptops, images and ratings added to the catalogs.
+// A nil *Metrics records nothing.
+type Metrics struct {
+	handled         *prometheus.CounterVec
+	handlingSeconds *prometheus.HistogramVec
+	streamsInFlight *prometheus.GaugeVec
+	msgReceived     *prometheus.CounterVec
+	msgSent         *prometheus.CounterVec
+
+	laptopsStored *prometheus.GaugeVec
+	imagesStored  *prometheus.GaugeVec
+	uploadedBytes *prometheus.CounterVec
+	ratingsAdded  *prometheus.CounterVec
+}
+
+// NewMetrics returns new Metrics registered with registerer.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Number of RPCs completed on the server, by method and status code.",
+		}, []string{"method", "code"}),
+		handlingSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Duration of the RPCs handled by the server, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		streamsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_streams_in_flight",
+			Help: "Number of streaming RPCs currently handled by the server, by method.",
+		}, []string{"method"}),
+		msgReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_received_total",
+			Help: "Number of stream messages received by the server, by method.",
+		}, []string{"method"}),
+		msgSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_sent_total",
+			Help: "Number of stream messages sent by the server, by method.",
+		}, []string{"method"}),
+		laptopsStored: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pcbook_laptops_stored",
+			Help: "Number of laptops in the catalogs, by tenant.",
+		}, []string{"tenant"}),
+		imagesStored: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pcbook_images_stored",
+			Help: "Number of laptop images uploaded, by tenant.",
+		}, []string{"tenant"}),
+		uploadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pcbook_image_uploaded_bytes_total",
+			Help: "Size of the laptop images uploaded, by tenant.",
+		}, []string{"tenant"}),
+		ratingsAdded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pcbook_ratings_added_total",
+			Help: "Number of laptop ratings added, by tenant.",
+		}, []string{"tenant"}),
+	}
+
+	registerer.MustRegister(
+		metrics.handled,
+		metrics.handlingSeconds,
+		metrics.streamsInFlight,
+		metrics.msgReceived,
+		metrics.msgSent,
+		metrics.laptopsStored,
+		metrics.imagesStored,
+		metrics.uploadedBytes,
+		metrics.ratingsAdded,
+	)
+	return metrics
+}
+
+// Unary returns a server interceptor function to record the metrics of unary RPC.
+func (metrics *Metrics) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+
+		metrics.observe(info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to record the metrics of stream RPC.
+func (metrics *Metrics) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		inFlight := metrics.streamsInFlight.WithLabelValues(info.FullMethod)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		err := handler(srv, &metricsServerStream{
+			ServerStream: ss,
+			received:     metrics.msgReceived.WithLabelValues(info.FullMethod),
+			sent:         metrics.msgSent.WithLabelValues(info.FullMethod),
+		})
+
+		metrics.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (metrics *Metrics) observe(method string, start time.Time, err error) {
+	metrics.handled.WithLabelValues(method, status.Code(err).String()).Inc()
+	metrics.handlingSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// laptopAdded records a laptop added to the catalog of tenant.
+func (metrics *Metrics) laptopAdded(tenant string) {
+	if metrics == nil {
+		return
+	}
+	metrics.laptopsStored.WithLabelValues(tenant).Inc()
+}
+
+// laptopDeleted records a laptop deleted from the catalog of tenant.
+func (metrics *Metrics) laptopDeleted(tenant string) {
+	if metrics == nil {
+		return
+	}
+	metrics.laptopsStored.WithLabelValues(tenant).Dec()
+}
+
+// imageUploaded records an image of size bytes uploaded to the catalog of tenant.
+func (metrics *Metrics) imageUploaded(tenant string, size int) {
+	if metrics == nil {
+		return
+	}
+	metrics.imagesStored.WithLabelValues(tenant).Inc()
+	metrics.uploadedBytes.WithLabelValues(tenant).Add(float64(size))
+}
+
+// ratingAdded records a rating added to the catalog of tenant.
+func (metrics *Metrics) ratingAdded(tenant string) {
+	if metrics == nil {
+		return
+	}
+	metrics.ratingsAdded.WithLabelValues(tenant).Inc()
+}
+
+// metricsServerStream is a grpc.ServerStream counting the messages it receives and sends.
+type metricsServerStream struct {
+	grpc.ServerStream
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func (stream *metricsServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.received.Inc()
+	}
+	return err
+}
+
+func (stream *metricsServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.sent.Inc()
+	}
+	return err
+}
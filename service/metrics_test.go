@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	metrics := service.NewMetrics(registry)
+
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), imageStore, service.NewInMemoryRatingStore())
+	laptopServer.SetMetrics(metrics)
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(metrics.Unary()),
+		grpc.StreamInterceptor(metrics.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+	ctx := context.Background()
+
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2} {
+		_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop1})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: laptop2.GetId()})
+	require.NoError(t, err)
+
+	rateStream, err := laptopClient.RateLaptop(ctx)
+	require.NoError(t, err)
+	for _, score := range []float64{7, 9} {
+		require.NoError(t, rateStream.Send(&pb.RateLaptopRequest{LaptopId: laptop1.GetId(), Score: score}))
+		_, err = rateStream.Recv()
+		require.NoError(t, err)
+	}
+	require.NoError(t, rateStream.CloseSend())
+	_, err = rateStream.Recv()
+	require.Error(t, err)
+
+	uploadStream, err := laptopClient.UploadImage(ctx)
+	require.NoError(t, err)
+	require.NoError(t, uploadStream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop1.GetId(), ImageType: ".jpg"}},
+	}))
+	require.NoError(t, uploadStream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, 1000)},
+	}))
+	_, err = uploadStream.CloseAndRecv()
+	require.NoError(t, err)
+
+	// the stream metrics are recorded once the handler returns
+	require.Eventually(t, func() bool {
+		count, err := testutil.GatherAndCount(registry, "grpc_server_handled_total")
+		return err == nil && count == 5
+	}, time.Second, 10*time.Millisecond)
+
+	requireMetric := func(expected float64, name string, labels ...string) {
+		t.Helper()
+		metricFamilies, err := registry.Gather()
+		require.NoError(t, err)
+
+		for _, family := range metricFamilies {
+			if family.GetName() != name {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				values := make([]string, 0, len(labels))
+				for _, label := range metric.GetLabel() {
+					values = append(values, label.GetValue())
+				}
+				if len(values) != len(labels) {
+					continue
+				}
+				matched := true
+				for i := range values {
+					if values[i] != labels[i] {
+						matched = false
+					}
+				}
+				if !matched {
+					continue
+				}
+
+				switch {
+				case metric.GetCounter() != nil:
+					require.Equal(t, expected, metric.GetCounter().GetValue(), name)
+				case metric.GetGauge() != nil:
+					require.Equal(t, expected, metric.GetGauge().GetValue(), name)
+				case metric.GetHistogram() != nil:
+					require.Equal(t, uint64(expected), metric.GetHistogram().GetSampleCount(), name)
+				}
+				return
+			}
+		}
+		require.Failf(t, "metric not found", "%s %v", name, labels)
+	}
+
+	requireMetric(2, "grpc_server_handled_total", codes.OK.String(), "/LaptopService/CreateLaptop")
+	requireMetric(1, "grpc_server_handled_total", codes.AlreadyExists.String(), "/LaptopService/CreateLaptop")
+	requireMetric(1, "grpc_server_handled_total", codes.OK.String(), "/LaptopService/RateLaptop")
+	requireMetric(3, "grpc_server_handling_seconds", "/LaptopService/CreateLaptop")
+	requireMetric(2, "grpc_server_msg_received_total", "/LaptopService/RateLaptop")
+	requireMetric(2, "grpc_server_msg_sent_total", "/LaptopService/RateLaptop")
+	requireMetric(0, "grpc_server_streams_in_flight", "/LaptopService/RateLaptop")
+	requireMetric(1, "pcbook_laptops_stored", service.DefaultTenant)
+	requireMetric(1, "pcbook_images_stored", service.DefaultTenant)
+	requireMetric(1000, "pcbook_image_uploaded_bytes_total", service.DefaultTenant)
+	requireMetric(2, "pcbook_ratings_added_total", service.DefaultTenant)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is returned when a password does not satisfy the password policy.
+var ErrWeakPassword = errors.New("password is too weak")
+
+// A PasswordPolicy defines the passwords that users can choose.
+type PasswordPolicy struct {
+	// MinLength is a number of characters.
+	MinLength int
+	// MaxLength is a number of bytes, since bcrypt ignores the bytes after the 72nd.
+	MaxLength int
+	// RequiredClasses is the number of character classes, among lowercase letters,
+	// uppercase letters, digits and symbols, that a password must contain.
+	RequiredClasses int
+
+	blocklist map[string]bool
+}
+
+// DefaultPasswordPolicy requires passwords of at least 8 characters and at most
+// 72 bytes, with at least 2 character classes.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:       8,
+	MaxLength:       72,
+	RequiredClasses: 2,
+}
+
+// LoadBlocklist rejects the passwords listed in a file, such as the passwords found in
+// data breaches. The file has one password per line, and lines starting with # are ignored.
+// Passwords are compared case-insensitively.
+func (policy *PasswordPolicy) LoadBlocklist(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open password blocklist: %w", err)
+	}
+	defer file.Close()
+
+	blocklist := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = true
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("cannot read password blocklist: %w", err)
+	}
+
+	policy.blocklist = blocklist
+	return nil
+}
+
+// Validate checks that the password of a user satisfies the policy.
+func (policy *PasswordPolicy) Validate(username, password string) error {
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		return fmt.Errorf("%w: it must have at least %d characters", ErrWeakPassword, policy.MinLength)
+	}
+
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		return fmt.Errorf("%w: it must have at most %d bytes", ErrWeakPassword, policy.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, class := range []bool{lower, upper, digit, symbol} {
+		if class {
+			classes++
+		}
+	}
+
+	if classes < policy.RequiredClasses {
+		return fmt.Errorf(
+			"%w: it must contain %d of lowercase letters, uppercase letters, digits and symbols",
+			ErrWeakPassword, policy.RequiredClasses,
+		)
+	}
+
+	if strings.EqualFold(password, username) {
+		return fmt.Errorf("%w: it must differ from the username", ErrWeakPassword)
+	}
+
+	if policy.blocklist[strings.ToLower(password)] {
+		return fmt.Errorf("%w: it is a commonly used password", ErrWeakPassword)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(blocklist, []byte("# common passwords\nPassword1\n\nletmein\n"), 0o644)
+	require.NoError(t, err)
+
+	policy := service.DefaultPasswordPolicy
+	err = policy.LoadBlocklist(blocklist)
+	require.NoError(t, err)
+
+	require.NoError(t, policy.Validate("alice", "alice-secret"))
+	require.NoError(t, policy.Validate("alice", "Correct horse 9"))
+
+	for _, password := range []string{
+		"short-1",
+		"ééé-éé1",
+		"alllowercase",
+		"12345678901",
+		"ALICE-SECRET!",
+		"password1",
+		strings.Repeat("a1", 40),
+		strings.Repeat("é1", 25),
+	} {
+		err = policy.Validate("alice-secret!", password)
+		require.ErrorIs(t, err, service.ErrWeakPassword, password)
+	}
+
+	err = policy.LoadBlocklist(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
+
+func TestPasswordHasher(t *testing.T) {
+	t.Parallel()
+
+	bcryptHasher := service.BcryptHasher{Cost: bcrypt.MinCost}
+	argon2idHasher := service.Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+
+	for _, hasher := range []service.PasswordHasher{bcryptHasher, argon2idHasher} {
+		user, err := service.NewUserWithHasher("alice", "alice-secret", service.RoleUser, hasher)
+		require.NoError(t, err)
+		require.True(t, user.VerfiyPassword("alice-secret"))
+		require.False(t, user.VerfiyPassword("wrong-secret"))
+		require.False(t, hasher.NeedsRehash(user.HashedPassword))
+	}
+
+	hash, err := argon2idHasher.Hash("alice-secret")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	require.True(t, bcryptHasher.NeedsRehash(hash))
+
+	stronger := argon2idHasher
+	stronger.Time = 2
+	require.True(t, stronger.NeedsRehash(hash))
+
+	hash, err = bcryptHasher.Hash("alice-secret")
+	require.NoError(t, err)
+	require.True(t, argon2idHasher.NeedsRehash(hash))
+	require.True(t, service.BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(hash))
+}
+
+func TestPasswordServer(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	authServer := service.NewAuthServer(userStore, newTestJWTManager(t), service.NewInMemoryRevocationStore())
+	ctx := context.Background()
+
+	_, err := authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "secret"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	aliceCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "alice", Role: service.RoleUser})
+	_, err = authServer.ChangePassword(aliceCtx, &pb.ChangePasswordRequest{OldPassword: "alice-secret", NewPassword: "alice"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	user, err := userStore.Find("alice")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(user.HashedPassword, "$2a$"))
+
+	// the bcrypt hash is upgraded to argon2id on the next successful login
+	argon2idHasher := service.Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+	authServer.SetPasswordHasher(argon2idHasher)
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong-secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	user, err = userStore.Find("alice")
+	require.NoError(t, err)
+	require.True(t, argon2idHasher.NeedsRehash(user.HashedPassword))
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+
+	user, err = userStore.Find("alice")
+	require.NoError(t, err)
+	require.False(t, argon2idHasher.NeedsRehash(user.HashedPassword))
+
+	_, err = authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+}
+
+func TestUserStoreUpdatePassword(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	err := userStore.Save(&service.User{Username: "alice", HashedPassword: "hash1", Role: service.RoleUser})
+	require.NoError(t, err)
+
+	// a user disabled while the password was rehashed stays disabled
+	user, err := userStore.Find("alice")
+	require.NoError(t, err)
+	user.Disabled = true
+	err = userStore.Update(user)
+	require.NoError(t, err)
+
+	err = userStore.UpdatePassword("alice", "hash1", "hash2")
+	require.NoError(t, err)
+
+	user, err = userStore.Find("alice")
+	require.NoError(t, err)
+	require.Equal(t, "hash2", user.HashedPassword)
+	require.True(t, user.Disabled)
+
+	// the hash is not replaced if it was changed in the meantime
+	err = userStore.UpdatePassword("alice", "hash1", "hash3")
+	require.ErrorIs(t, err, service.ErrConflict)
+
+	err = userStore.UpdatePassword("bob", "hash1", "hash2")
+	require.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestLoginUnknownUserHasher(t *testing.T) {
+	t.Parallel()
+
+	authServer := service.NewAuthServer(service.NewInMemoryUserStore(), newTestJWTManager(t), service.NewInMemoryRevocationStore())
+	ctx := context.Background()
+
+	// the unknown usernames are checked against a hash of the configured hasher,
+	// computed once
+	hasher := &countingHasher{PasswordHasher: service.BcryptHasher{Cost: bcrypt.MinCost}}
+	authServer.SetPasswordHasher(hasher)
+	for i := 0; i < 2; i++ {
+		_, err := authServer.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "secret"})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+	require.Equal(t, 1, hasher.hashes)
+
+	// and again with a new hasher
+	other := &countingHasher{PasswordHasher: service.BcryptHasher{Cost: bcrypt.MinCost}}
+	authServer.SetPasswordHasher(other)
+	_, err := authServer.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Equal(t, 1, other.hashes)
+}
+
+// countingHasher counts the passwords hashed by its PasswordHasher.
+type countingHasher struct {
+	service.PasswordHasher
+	hashes int
+}
+
+func (hasher *countingHasher) Hash(password string) (string, error) {
+	hasher.hashes++
+	return hasher.PasswordHasher.Hash(password)
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Permission allows an action on a kind of resource, written as "resource:action".
+type Permission string
+
+// Permissions checked by the services.
+const (
+	PermissionLaptopRead   Permission = "laptop:read"
+	PermissionLaptopCreate Permission = "laptop:create"
+	PermissionLaptopUpdate Permission = "laptop:update"
+	PermissionLaptopDelete Permission = "laptop:delete"
+	PermissionImageUpload  Permission = "image:upload"
+	PermissionRatingRead   Permission = "rating:read"
+	PermissionRatingWrite  Permission = "rating:write"
+	PermissionAccountWrite Permission = "account:write"
+	PermissionUserAdmin    Permission = "user:admin"
+	PermissionWebhookAdmin Permission = "webhook:admin"
+	PermissionAPIKeyAdmin  Permission = "api_key:admin"
+	PermissionTenantAdmin  Permission = "tenant:admin"
+	PermissionAuditRead    Permission = "audit:read"
+)
+
+// A MethodPolicy is the access rule of an RPC.
+type MethodPolicy struct {
+	// Public methods can be called without authentication.
+	Public bool `json:"public,omitempty"`
+	// Permissions are all required to call a method that is not public.
+	Permissions []Permission `json:"permissions,omitempty"`
+}
+
+// A Policy grants permissions to roles, and requires permissions to call RPCs.
+// Methods are identified by their full name, such as "/LaptopService/CreateLaptop",
+// or by "/Service/*" for all the methods of a service. Methods that the policy does
+// not list cannot be called. The rules of most methods are declared by the auth
+// option in the proto files, and added with AddMethods.
+type Policy struct {
+	Roles   map[string][]Permission `json:"roles"`
+	Methods map[string]MethodPolicy `json:"methods"`
+
+	rolePermissions map[string]map[Permission]bool
+}
+
+// LoadPolicy reads a JSON policy file.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy file: %w", err)
+	}
+
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load policy file %s: %w", filename, err)
+	}
+
+	return policy, nil
+}
+
+// ParsePolicy parses and validates a JSON policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	err := json.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse policy: %w", err)
+	}
+
+	err = policy.init()
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (policy *Policy) init() error {
+	policy.rolePermissions = make(map[string]map[Permission]bool, len(policy.Roles))
+	for role, permissions := range policy.Roles {
+		policy.rolePermissions[role] = make(map[Permission]bool, len(permissions))
+		for _, permission := range permissions {
+			err := validatePermission(permission)
+			if err != nil {
+				return fmt.Errorf("role %s: %w", role, err)
+			}
+			policy.rolePermissions[role][permission] = true
+		}
+	}
+
+	for method, rule := range policy.Methods {
+		err := validateMethodPolicy(method, rule)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetRole grants the permissions to a role, replacing the permissions it had.
+func (policy *Policy) SetRole(role string, permissions []Permission) error {
+	granted := make(map[Permission]bool, len(permissions))
+	for _, permission := range permissions {
+		err := validatePermission(permission)
+		if err != nil {
+			return fmt.Errorf("role %s: %w", role, err)
+		}
+		granted[permission] = true
+	}
+
+	if policy.Roles == nil {
+		policy.Roles = make(map[string][]Permission)
+		policy.rolePermissions = make(map[string]map[Permission]bool)
+	}
+	policy.Roles[role] = permissions
+	policy.rolePermissions[role] = granted
+	return nil
+}
+
+// AddMethods adds the access rules of the methods that the policy does not list yet,
+// so that the policy file can override the rules declared in the proto files.
+func (policy *Policy) AddMethods(methods map[string]MethodPolicy) error {
+	if policy.Methods == nil {
+		policy.Methods = make(map[string]MethodPolicy, len(methods))
+	}
+
+	for method, rule := range methods {
+		if _, ok := policy.Methods[method]; ok {
+			continue
+		}
+
+		err := validateMethodPolicy(method, rule)
+		if err != nil {
+			return err
+		}
+		policy.Methods[method] = rule
+	}
+
+	return nil
+}
+
+func validateMethodPolicy(method string, rule MethodPolicy) error {
+	if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+		return fmt.Errorf("invalid method name %q", method)
+	}
+
+	if rule.Public && len(rule.Permissions) > 0 {
+		return fmt.Errorf("method %s: public method cannot require permissions", method)
+	}
+
+	if !rule.Public && len(rule.Permissions) == 0 {
+		return fmt.Errorf("method %s: method must be public or require permissions", method)
+	}
+
+	for _, permission := range rule.Permissions {
+		err := validatePermission(permission)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", method, err)
+		}
+	}
+
+	return nil
+}
+
+func validatePermission(permission Permission) error {
+	resource, action, ok := strings.Cut(string(permission), ":")
+	if !ok || resource == "" || action == "" {
+		return fmt.Errorf("invalid permission %q", permission)
+	}
+	return nil
+}
+
+// MethodPolicy returns the access rule of a method, or false if the method
+// is not listed by the policy.
+func (policy *Policy) MethodPolicy(method string) (MethodPolicy, bool) {
+	rule, ok := policy.Methods[method]
+	if ok {
+		return rule, true
+	}
+
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		return MethodPolicy{}, false
+	}
+
+	rule, ok = policy.Methods[method[:i]+"/*"]
+	return rule, ok
+}
+
+// HasRole reports whether the policy defines a role.
+func (policy *Policy) HasRole(role string) bool {
+	_, ok := policy.Roles[role]
+	return ok
+}
+
+// HasPermissions reports whether a role is granted all the permissions.
+func (policy *Policy) HasPermissions(role string, permissions []Permission) bool {
+	granted := policy.rolePermissions[role]
+	for _, permission := range permissions {
+		if !granted[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// PrincipalHasPermissions reports whether a principal is granted all the permissions:
+// those of its API key if the key is scoped to permissions, or else those of its role.
+func (policy *Policy) PrincipalHasPermissions(principal *Principal, permissions []Permission) bool {
+	if len(principal.Permissions) == 0 {
+		return policy.HasPermissions(principal.Role, permissions)
+	}
+
+	for _, permission := range permissions {
+		granted := false
+		for _, p := range principal.Permissions {
+			if p == permission {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
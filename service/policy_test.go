@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/client"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := loadTestPolicy(t)
+
+	rule, ok := policy.MethodPolicy("/AuthService/Login")
+	require.True(t, ok)
+	require.True(t, rule.Public)
+
+	rule, ok = policy.MethodPolicy("/WebhookService/ListWebhooks")
+	require.True(t, ok)
+	require.Equal(t, []service.Permission{service.PermissionWebhookAdmin}, rule.Permissions)
+
+	_, ok = policy.MethodPolicy("/LaptopService/Unknown")
+	require.False(t, ok)
+
+	require.True(t, policy.HasPermissions(service.RoleUser, []service.Permission{service.PermissionRatingWrite}))
+	require.False(t, policy.HasPermissions(service.RoleUser, []service.Permission{service.PermissionLaptopCreate}))
+	require.False(t, policy.HasPermissions("guest", []service.Permission{service.PermissionLaptopRead}))
+
+	_, err := service.ParsePolicy([]byte(`{"methods": {"/LaptopService/SearchLaptop": {}}}`))
+	require.Error(t, err)
+
+	// the policy file overrides the rules of the proto files
+	override, err := service.ParsePolicy([]byte(`{"methods": {"/LaptopService/SearchLaptop": {"public": true}}}`))
+	require.NoError(t, err)
+	err = override.AddMethods(service.MethodPolicies(protoregistry.GlobalFiles))
+	require.NoError(t, err)
+	rule, ok = override.MethodPolicy("/LaptopService/SearchLaptop")
+	require.True(t, ok)
+	require.True(t, rule.Public)
+
+	_, err = service.ParsePolicy([]byte(`{"roles": {"user": ["laptop"]}}`))
+	require.Error(t, err)
+
+	// the roles of the configuration replace those of the policy file
+	err = override.SetRole(service.RoleUser, []service.Permission{service.PermissionLaptopRead})
+	require.NoError(t, err)
+	require.True(t, override.HasPermissions(service.RoleUser, []service.Permission{service.PermissionLaptopRead}))
+	require.False(t, override.HasPermissions(service.RoleUser, []service.Permission{service.PermissionRatingWrite}))
+
+	err = override.SetRole("guest", []service.Permission{"laptop"})
+	require.Error(t, err)
+}
+
+func TestAuthInterceptorPolicy(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := newTestJWTManager(t)
+	interceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+
+	userToken, err := jwtManager.GenerateToken(&service.User{Username: "user1", Role: service.RoleUser})
+	require.NoError(t, err)
+
+	call := func(method string, accessToken string) codes.Code {
+		if accessToken == "" {
+			return authorizeTestCall(interceptor, method)
+		}
+		return authorizeTestCall(interceptor, method, "authorization", accessToken)
+	}
+
+	require.Equal(t, codes.OK, call("/AuthService/Login", ""))
+	require.Equal(t, codes.Unauthenticated, call("/LaptopService/SearchLaptop", ""))
+	require.Equal(t, codes.OK, call("/LaptopService/SearchLaptop", userToken))
+	require.Equal(t, codes.OK, call("/LaptopService/SearchLaptop", "Bearer "+userToken))
+	require.Equal(t, codes.PermissionDenied, call("/LaptopService/CreateLaptop", userToken))
+	require.Equal(t, codes.PermissionDenied, call("/LaptopService/Unknown", userToken))
+	require.Equal(t, codes.PermissionDenied, call("/UnknownService/Method", ""))
+
+	// a reloaded policy applies to the next calls
+	reloaded := loadTestPolicy(t)
+	err = reloaded.SetRole(service.RoleUser, []service.Permission{service.PermissionLaptopRead, service.PermissionLaptopCreate})
+	require.NoError(t, err)
+	interceptor.SetPolicy(reloaded)
+	require.Equal(t, codes.OK, call("/LaptopService/CreateLaptop", userToken))
+}
+
+func TestClientAuthMethods(t *testing.T) {
+	t.Parallel()
+
+	authMethods := client.AuthMethods()
+	require.True(t, authMethods["/LaptopService/CreateLaptop"])
+	require.True(t, authMethods["/LaptopService/SearchLaptop"])
+	require.True(t, authMethods["/WebhookService/ListWebhooks"])
+	require.False(t, authMethods["/AuthService/Login"])
+	require.False(t, authMethods["/AuthService/RefreshToken"])
+}
+
+// authorizeTestCall calls a unary RPC through the interceptor with the metadata pairs,
+// and returns the status code of the call.
+func authorizeTestCall(interceptor *service.AuthInterceptor, method string, pairs ...string) codes.Code {
+	ctx := context.Background()
+	if len(pairs) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(pairs...))
+	}
+
+	return authorizeTestContext(interceptor, ctx, method)
+}
+
+func authorizeTestContext(interceptor *service.AuthInterceptor, ctx context.Context, method string) codes.Code {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	_, err := interceptor.Unary()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	return status.Code(err)
+}
+
+func loadTestPolicy(t *testing.T) *service.Policy {
+	policy, err := service.LoadPolicy("../policy.json")
+	require.NoError(t, err)
+
+	err = policy.AddMethods(service.MethodPolicies(protoregistry.GlobalFiles))
+	require.NoError(t, err)
+
+	return policy
+}
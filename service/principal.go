@@ -0,0 +1,59 @@
+package service
+
+import "context"
+
+// A Principal is the authenticated caller of an RPC: a user, or a service
+// authenticated with an API key.
+type Principal struct {
+	Username string
+	Role     string
+	// APIKeyID is set for the callers authenticated with an API key.
+	APIKeyID string
+	// Permissions are the permissions of API keys scoped to permissions rather than a role.
+	Permissions []Permission
+	// Tenant is the tenant that the RPC acts on: the caller's own tenant, or the one
+	// selected in the x-tenant metadata by a caller with CrossTenant access.
+	Tenant string
+	// CrossTenant is set for the callers with the tenant:admin permission.
+	CrossTenant bool
+}
+
+type principalKey struct{}
+
+// principalRecorder is stored in the context of an RPC by the interceptors that run
+// before the AuthInterceptor, to learn the principal that it authenticates.
+type principalRecorder struct {
+	principal *Principal
+}
+
+type principalRecorderKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx that carries the principal.
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	recordPrincipal(ctx, principal)
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// recordPrincipal reports the principal authenticated for an RPC to the recorder of ctx, if any.
+func recordPrincipal(ctx context.Context, principal *Principal) {
+	if recorder, ok := ctx.Value(principalRecorderKey{}).(*principalRecorder); ok {
+		recorder.principal = principal
+	}
+}
+
+// contextWithPrincipalRecorder returns a copy of ctx recording the principal stored
+// in the contexts derived from it. The interceptors of an RPC share its recorder.
+func contextWithPrincipalRecorder(ctx context.Context) (context.Context, *principalRecorder) {
+	if recorder, ok := ctx.Value(principalRecorderKey{}).(*principalRecorder); ok {
+		return ctx, recorder
+	}
+
+	recorder := &principalRecorder{}
+	return context.WithValue(ctx, principalRecorderKey{}, recorder), recorder
+}
+
+// PrincipalFromContext returns the principal stored in ctx by the AuthInterceptor, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// TenantQuotas limit the resources of each tenant. The zero quotas are not limited.
+type TenantQuotas struct {
+	// MaxLaptops is the number of laptops in the catalog of a tenant.
+	MaxLaptops int
+	// DailyUploadBytes is the size of the images uploaded to the catalog of a tenant
+	// during a UTC day.
+	DailyUploadBytes int
+}
+
+// quotaExceeded returns a ResourceExhausted error with a QuotaFailure detail of the
+// subject exceeding its quota, and a RetryInfo detail if the call can be retried after
+// retryDelay.
+func quotaExceeded(subject, description string, retryDelay time.Duration) error {
+	st := status.New(codes.ResourceExhausted, description)
+
+	failure := &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: subject, Description: description},
+		},
+	}
+
+	var err error
+	if retryDelay > 0 {
+		st, err = st.WithDetails(failure, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryDelay)})
+	} else {
+		st, err = st.WithDetails(failure)
+	}
+	if err != nil {
+		return status.Errorf(codes.ResourceExhausted, "%s", description)
+	}
+
+	return st.Err()
+}
+
+// dailyUploads counts the bytes uploaded to the catalog of each tenant during the
+// current UTC day.
+type dailyUploads struct {
+	mutex sync.Mutex
+	day   time.Time
+	bytes map[string]int
+}
+
+// reserve adds size bytes to the uploads of tenant on the day of now, unless they exceed
+// quota, in which case it returns the time until the next day.
+func (uploads *dailyUploads) reserve(tenant string, size, quota int, now time.Time) (time.Duration, bool) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	day := utcDay(now)
+	if !day.Equal(uploads.day) {
+		uploads.day = day
+		uploads.bytes = make(map[string]int)
+	}
+
+	if uploads.bytes[tenant]+size > quota {
+		return day.AddDate(0, 0, 1).Sub(now), false
+	}
+
+	uploads.bytes[tenant] += size
+	return 0, true
+}
+
+// release removes size bytes reserved on the day of now from the uploads of tenant.
+func (uploads *dailyUploads) release(tenant string, size int, now time.Time) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	if utcDay(now).Equal(uploads.day) {
+		uploads.bytes[tenant] -= size
+	}
+}
+
+func utcDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
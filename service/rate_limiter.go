@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+// rateLimitSweepInterval is the interval at which the buckets of the idle callers are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// defaultRateLimitPattern is the pattern of the methods limited by the default limit.
+const defaultRateLimitPattern = "*"
+
+// A RateLimit limits the calls of each caller to a method. The zero fields are not limited.
+type RateLimit struct {
+	// Rate is the number of calls per second allowed on average, refilling a token bucket
+	// of Burst calls.
+	Rate  float64
+	Burst int
+	// MaxConcurrent is the number of calls allowed in progress at once, such as open streams.
+	MaxConcurrent int
+}
+
+// Validate checks that the limit is not negative, and that a rate has a burst.
+func (limit RateLimit) Validate() error {
+	switch {
+	case limit.Rate < 0:
+		return errors.New("rate must not be negative")
+	case limit.Burst < 0:
+		return errors.New("burst must not be negative")
+	case limit.Rate > 0 && limit.Burst == 0:
+		return errors.New("burst must be positive with a rate")
+	case limit.MaxConcurrent < 0:
+		return errors.New("max concurrent calls must not be negative")
+	}
+	return nil
+}
+
+// ValidateMethodPattern checks that a pattern is a full method name, such as
+// /LaptopService/CreateLaptop, or selects every method of a service, such as /LaptopService/*.
+func ValidateMethodPattern(pattern string) error {
+	service, method, ok := strings.Cut(strings.TrimPrefix(pattern, "/"), "/")
+	if !strings.HasPrefix(pattern, "/") || !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return fmt.Errorf("invalid method pattern %q", pattern)
+	}
+	return nil
+}
+
+// RateLimiter is a server interceptor limiting the calls of each caller with token buckets,
+// and the number of calls that it has in progress. The callers are identified by their
+// API key, their username, or else their IP address, so it must run after the AuthInterceptor.
+// The rejected calls fail with ResourceExhausted, and the delay after which they can be
+// retried in a RetryInfo detail.
+type RateLimiter struct {
+	defaultLimit RateLimit
+	limits       map[string]RateLimit
+
+	mutex     sync.Mutex
+	buckets   map[rateBucketKey]*rateBucket
+	lastSweep time.Time
+}
+
+// rateBucketKey identifies the bucket of a caller for the methods of a pattern.
+type rateBucketKey struct {
+	pattern string
+	caller  string
+}
+
+// rateBucket holds the tokens and the calls in progress of a caller.
+type rateBucket struct {
+	limiter  *rate.Limiter
+	inFlight int
+}
+
+// NewRateLimiter returns a RateLimiter applying defaultLimit to the methods without
+// their own limit. The calls of a caller to all these methods share the same bucket.
+func NewRateLimiter(defaultLimit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		defaultLimit: defaultLimit,
+		limits:       make(map[string]RateLimit),
+		buckets:      make(map[rateBucketKey]*rateBucket),
+	}
+}
+
+// SetMethodLimit sets the limit of the methods selected by a pattern, either a full method
+// name or a service followed by /*. The limit of a method takes precedence over the one
+// of its service, and the calls to all the methods of a service share the same bucket.
+func (limiter *RateLimiter) SetMethodLimit(pattern string, limit RateLimit) error {
+	err := ValidateMethodPattern(pattern)
+	if err != nil {
+		return err
+	}
+	err = limit.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid rate limit of %s: %w", pattern, err)
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.limits[pattern] = limit
+	for key := range limiter.buckets {
+		if key.pattern == pattern {
+			delete(limiter.buckets, key)
+		}
+	}
+	return nil
+}
+
+// Unary returns a server interceptor function to limit unary RPC.
+func (limiter *RateLimiter) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		release, err := limiter.acquire(info.FullMethod, rateLimitCaller(ctx), time.Now())
+		if err != nil {
+			return nil, logError(ctx, err)
+		}
+		defer release()
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to limit stream RPC. A stream is
+// in progress until it ends.
+func (limiter *RateLimiter) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		release, err := limiter.acquire(info.FullMethod, rateLimitCaller(stream.Context()), time.Now())
+		if err != nil {
+			return logError(stream.Context(), err)
+		}
+		defer release()
+
+		return handler(srv, stream)
+	}
+}
+
+// acquire starts a call of caller to method at time now, and returns the function ending
+// it, or the error rejecting the call.
+func (limiter *RateLimiter) acquire(method, caller string, now time.Time) (func(), error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	pattern, limit := limiter.limit(method)
+	if limit == (RateLimit{}) {
+		return func() {}, nil
+	}
+
+	limiter.sweep(now)
+
+	key := rateBucketKey{pattern: pattern, caller: caller}
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		bucket = &rateBucket{}
+		if limit.Rate > 0 {
+			bucket.limiter = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		}
+		limiter.buckets[key] = bucket
+	}
+
+	if limit.MaxConcurrent > 0 && bucket.inFlight >= limit.MaxConcurrent {
+		return nil, quotaExceeded(caller, fmt.Sprintf("too many calls in progress to %s: the limit is %d", method, limit.MaxConcurrent), 0)
+	}
+
+	if bucket.limiter != nil {
+		reservation := bucket.limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			reservation.CancelAt(now)
+			return nil, quotaExceeded(caller, fmt.Sprintf("too many calls to %s: the limit is %g per second", method, limit.Rate), delay)
+		}
+	}
+
+	bucket.inFlight++
+	return func() {
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+
+		bucket.inFlight--
+	}, nil
+}
+
+// limit returns the limit of a method, and the pattern that selects it.
+func (limiter *RateLimiter) limit(method string) (string, RateLimit) {
+	if limit, ok := limiter.limits[method]; ok {
+		return method, limit
+	}
+
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	pattern := "/" + service + "/*"
+	if limit, ok := limiter.limits[pattern]; ok {
+		return pattern, limit
+	}
+
+	return defaultRateLimitPattern, limiter.defaultLimit
+}
+
+// sweep drops the buckets of the callers without calls in progress whose bucket is full,
+// as a new bucket would be, at most once every rateLimitSweepInterval.
+func (limiter *RateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if bucket.inFlight > 0 {
+			continue
+		}
+		if bucket.limiter != nil && bucket.limiter.TokensAt(now) < float64(bucket.limiter.Burst()) {
+			continue
+		}
+		delete(limiter.buckets, key)
+	}
+}
+
+// rateLimitCaller identifies the caller of an RPC by its API key, its username, or else
+// its IP address.
+func rateLimitCaller(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	switch {
+	case ok && principal.APIKeyID != "":
+		return "api_key:" + principal.APIKeyID
+	case ok && principal.Username != "":
+		return "user:" + principal.Username
+	}
+	return "ip:" + peerIP(ctx)
+}
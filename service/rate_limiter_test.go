@@ -0,0 +1,185 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(service.RateLimit{})
+	err := limiter.SetMethodLimit("/LaptopService/CreateLaptop", service.RateLimit{Rate: 0.01, Burst: 2})
+	require.NoError(t, err)
+	err = limiter.SetMethodLimit("/AuthService/*", service.RateLimit{Rate: 0.01, Burst: 1})
+	require.NoError(t, err)
+
+	require.Error(t, limiter.SetMethodLimit("LaptopService.SearchLaptop", service.RateLimit{}))
+	require.Error(t, limiter.SetMethodLimit("/LaptopService/SearchLaptop", service.RateLimit{Rate: 1}))
+
+	user1 := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "user1"})
+	user2 := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "user2"})
+	apiKey := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "user1", APIKeyID: "key1"})
+
+	call := func(ctx context.Context, method string) error {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := limiter.Unary()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	// the burst is allowed, then the caller has to wait for the next token
+	require.NoError(t, call(user1, "/LaptopService/CreateLaptop"))
+	require.NoError(t, call(user1, "/LaptopService/CreateLaptop"))
+	err = call(user1, "/LaptopService/CreateLaptop")
+	requireQuotaFailure(t, err, "user:user1", true)
+
+	retryInfo := findErrorDetail[*errdetails.RetryInfo](err)
+	require.InDelta(t, 100*time.Second, retryInfo.GetRetryDelay().AsDuration(), float64(time.Second))
+
+	// the other callers and the API keys have their own buckets
+	require.NoError(t, call(user2, "/LaptopService/CreateLaptop"))
+	require.NoError(t, call(apiKey, "/LaptopService/CreateLaptop"))
+
+	// the methods without a limit are not limited
+	for i := 0; i < 5; i++ {
+		require.NoError(t, call(user1, "/LaptopService/SearchLaptop"))
+	}
+
+	// the methods of a service share the limit of the service, and the callers without
+	// a principal are identified by their IP address
+	anonymous := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+	require.NoError(t, call(anonymous, "/AuthService/Login"))
+	requireQuotaFailure(t, call(anonymous, "/AuthService/RefreshToken"), "ip:10.0.0.1", true)
+}
+
+func TestRateLimiterMaxConcurrent(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(service.RateLimit{MaxConcurrent: 1})
+	ctx := service.ContextWithPrincipal(context.Background(), &service.Principal{Username: "user1"})
+	info := &grpc.StreamServerInfo{FullMethod: "/LaptopService/RateLaptop"}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	result := make(chan error)
+	go func() {
+		result <- limiter.Stream()(nil, &testServerStream{ctx: ctx}, info, func(srv interface{}, stream grpc.ServerStream) error {
+			close(started)
+			<-done
+			return nil
+		})
+	}()
+	<-started
+
+	open := func() error {
+		return limiter.Stream()(nil, &testServerStream{ctx: ctx}, info, func(srv interface{}, stream grpc.ServerStream) error {
+			return nil
+		})
+	}
+
+	// a second stream cannot be opened while the first one is in progress
+	requireQuotaFailure(t, open(), "user:user1", false)
+
+	close(done)
+	require.NoError(t, <-result)
+	require.NoError(t, open())
+}
+
+func TestLaptopServerQuotas(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), service.NewDiskImageStore(t.TempDir()), nil)
+	laptopServer.SetQuotas(service.TenantQuotas{MaxLaptops: 1, DailyUploadBytes: 1000})
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	laptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	requireQuotaFailure(t, err, "tenant:default", false)
+
+	upload := func(size int) error {
+		stream, err := laptopClient.UploadImage(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"}},
+		})
+		require.NoError(t, err)
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, size)},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	require.NoError(t, upload(600))
+	err = upload(600)
+	requireQuotaFailure(t, err, "tenant:default", true)
+	// the rejected upload does not count
+	require.NoError(t, upload(400))
+}
+
+// requireQuotaFailure checks that err is ResourceExhausted with a QuotaFailure of subject,
+// and a RetryInfo if retry is set.
+func requireQuotaFailure(t *testing.T, err error, subject string, retry bool) {
+	require.Equal(t, codes.ResourceExhausted, status.Code(err), "%v", err)
+
+	failure := findErrorDetail[*errdetails.QuotaFailure](err)
+	require.NotNil(t, failure)
+	require.Len(t, failure.GetViolations(), 1)
+	require.Equal(t, subject, failure.GetViolations()[0].GetSubject())
+
+	retryInfo := findErrorDetail[*errdetails.RetryInfo](err)
+	if retry {
+		require.NotNil(t, retryInfo)
+		require.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+	} else {
+		require.Nil(t, retryInfo)
+	}
+}
+
+func findErrorDetail[T any](err error) T {
+	var zero T
+	for _, detail := range status.Convert(err).Details() {
+		if found, ok := detail.(T); ok {
+			return found
+		}
+	}
+	return zero
+}
+
+// testServerStream is a grpc.ServerStream with a context, for the stream interceptors.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *testServerStream) Context() context.Context {
+	return stream.ctx
+}
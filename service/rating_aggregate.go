@@ -0,0 +1,102 @@
+package service
+
+import (
+	"math"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+)
+
+// ratingConfidenceZ is the z-score of the 95% confidence bounds.
+const ratingConfidenceZ = 1.96
+
+// A RatingPrior is the score a laptop is assumed to have before it is rated.
+// It counts as Weight ratings of Mean in the Bayesian average, so that a laptop
+// needs several ratings before its score moves far from Mean.
+type RatingPrior struct {
+	Mean   float64
+	Weight float64
+}
+
+// DefaultRatingPrior returns a prior of 5 ratings at the middle of the scale.
+func DefaultRatingPrior(scale RatingScale) RatingPrior {
+	return RatingPrior{
+		Mean:   (scale.Min + scale.Max) / 2,
+		Weight: 5,
+	}
+}
+
+// Average returns the mean of all scores.
+func (rating *Rating) Average() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	return rating.Sum / float64(rating.Count)
+}
+
+// BayesianAverage returns the mean of all scores pulled toward the prior mean.
+func (rating *Rating) BayesianAverage(prior RatingPrior) float64 {
+	if float64(rating.Count)+prior.Weight == 0 {
+		return prior.Mean
+	}
+	return (prior.Mean*prior.Weight + rating.Sum) / (prior.Weight + float64(rating.Count))
+}
+
+// DecayedAverage returns the mean of all scores weighted by their age.
+func (rating *Rating) DecayedAverage() float64 {
+	if rating.DecayedCount == 0 {
+		return 0
+	}
+	return rating.DecayedSum / rating.DecayedCount
+}
+
+// ConfidenceBounds returns the Wilson score interval of the average, mapped on the scale.
+// The interval narrows as the number of ratings grows.
+func (rating *Rating) ConfidenceBounds(scale RatingScale) (lower, upper float64) {
+	if rating.Count == 0 {
+		return scale.Min, scale.Max
+	}
+
+	width := scale.Max - scale.Min
+	p := math.Min(math.Max((rating.Average()-scale.Min)/width, 0), 1)
+	n := float64(rating.Count)
+	z2 := ratingConfidenceZ * ratingConfidenceZ
+
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := ratingConfidenceZ * math.Sqrt(p*(1-p)/n+z2/(4*n*n)) / (1 + z2/n)
+
+	return scale.Min + (center-margin)*width, scale.Min + (center+margin)*width
+}
+
+// ratingResponse returns the aggregates of a laptop rating, which may be nil if it is not rated.
+func (server *LaptopServer) ratingResponse(laptopID string, rating *Rating) *pb.RateLaptopResponse {
+	if rating == nil {
+		rating = &Rating{}
+	}
+
+	lower, upper := rating.ConfidenceBounds(server.ratingScale)
+	return &pb.RateLaptopResponse{
+		LaptopId:        laptopID,
+		RatingCount:     rating.Count,
+		AverageScore:    rating.Average(),
+		BayesianScore:   rating.BayesianAverage(server.ratingPrior),
+		DecayedScore:    rating.DecayedAverage(),
+		ScoreLowerBound: lower,
+		ScoreUpperBound: upper,
+	}
+}
+
+// ratingSortValue returns the value of the rating used to sort search results by key.
+func ratingSortValue(rating *pb.RateLaptopResponse, key pb.SearchLaptopRequest_SortKey) float64 {
+	switch key {
+	case pb.SearchLaptopRequest_AVERAGE_SCORE:
+		return rating.GetAverageScore()
+	case pb.SearchLaptopRequest_BAYESIAN_SCORE:
+		return rating.GetBayesianScore()
+	case pb.SearchLaptopRequest_DECAYED_SCORE:
+		return rating.GetDecayedScore()
+	case pb.SearchLaptopRequest_SCORE_LOWER_BOUND:
+		return rating.GetScoreLowerBound()
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+)
+
+// ErrSubscriberTooSlow is returned when a subscriber falls too far behind the updates.
+var ErrSubscriberTooSlow = errors.New("subscriber is too slow")
+
+// maxPendingRatings is the number of laptops a subscription can have unread updates for.
+const maxPendingRatings = 1024
+
+// A RatingHub fans out rating updates to its subscribers.
+type RatingHub struct {
+	mutex         sync.RWMutex
+	subscriptions map[*RatingSubscription]bool
+	// ratingCounts are the rating counts of the latest updates published for each laptop.
+	ratingCounts map[string]uint32
+}
+
+// NewRatingHub returns a new RatingHub without subscribers.
+func NewRatingHub() *RatingHub {
+	return &RatingHub{
+		subscriptions: make(map[*RatingSubscription]bool),
+		ratingCounts:  make(map[string]uint32),
+	}
+}
+
+// Subscribe returns a subscription to the updates of the given laptops,
+// or of every laptop if laptopIDs is empty. It must be closed when no longer used.
+func (hub *RatingHub) Subscribe(laptopIDs []string) *RatingSubscription {
+	subscription := &RatingSubscription{
+		hub:     hub,
+		pending: make(map[string]*pb.RateLaptopResponse),
+		ready:   make(chan struct{}, 1),
+	}
+	if len(laptopIDs) > 0 {
+		subscription.laptopIDs = make(map[string]bool)
+		for _, id := range laptopIDs {
+			subscription.laptopIDs[id] = true
+		}
+	}
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.subscriptions[subscription] = true
+	return subscription
+}
+
+// Publish sends a rating update to the subscribers of the laptop. It never blocks.
+// The ratings are added concurrently, so an update older than the latest one published
+// for the laptop, with fewer ratings, is dropped rather than sent after it.
+func (hub *RatingHub) Publish(rating *pb.RateLaptopResponse) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	laptopID := rating.GetLaptopId()
+	if count, ok := hub.ratingCounts[laptopID]; ok && count >= rating.GetRatingCount() {
+		return
+	}
+	hub.ratingCounts[laptopID] = rating.GetRatingCount()
+
+	for subscription := range hub.subscriptions {
+		subscription.push(rating)
+	}
+}
+
+// A RatingSubscription receives the rating updates of a set of laptops.
+//
+// Updates are buffered per laptop: when a subscriber reads slower than ratings
+// are added, it only receives the latest aggregate of each laptop instead of
+// holding up the publisher. A subscriber is dropped with ErrSubscriberTooSlow
+// once it has unread updates for too many laptops, which can only happen when it
+// watches every laptop or more than maxPendingRatings of them.
+type RatingSubscription struct {
+	hub       *RatingHub
+	laptopIDs map[string]bool
+
+	mutex   sync.Mutex
+	pending map[string]*pb.RateLaptopResponse
+	order   []string
+	err     error
+	ready   chan struct{}
+}
+
+func (subscription *RatingSubscription) push(rating *pb.RateLaptopResponse) {
+	laptopID := rating.GetLaptopId()
+	if subscription.laptopIDs != nil && !subscription.laptopIDs[laptopID] {
+		return
+	}
+
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+
+	if subscription.err != nil {
+		return
+	}
+
+	if subscription.pending[laptopID] == nil {
+		if len(subscription.order) >= maxPendingRatings {
+			subscription.err = ErrSubscriberTooSlow
+			subscription.pending = nil
+			subscription.order = nil
+			subscription.notify()
+			return
+		}
+		subscription.order = append(subscription.order, laptopID)
+	}
+	if pending := subscription.pending[laptopID]; pending == nil || pending.GetRatingCount() < rating.GetRatingCount() {
+		subscription.pending[laptopID] = rating
+	}
+	subscription.notify()
+}
+
+func (subscription *RatingSubscription) notify() {
+	select {
+	case subscription.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Next waits for the next rating update, in the order the laptops were first updated.
+func (subscription *RatingSubscription) Next(ctx context.Context) (*pb.RateLaptopResponse, error) {
+	for {
+		subscription.mutex.Lock()
+		if subscription.err != nil {
+			subscription.mutex.Unlock()
+			return nil, subscription.err
+		}
+		if len(subscription.order) > 0 {
+			laptopID := subscription.order[0]
+			subscription.order = subscription.order[1:]
+			rating := subscription.pending[laptopID]
+			delete(subscription.pending, laptopID)
+			subscription.mutex.Unlock()
+			return rating, nil
+		}
+		subscription.mutex.Unlock()
+
+		select {
+		case <-subscription.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close unsubscribes from the hub.
+func (subscription *RatingSubscription) Close() {
+	subscription.hub.mutex.Lock()
+	defer subscription.hub.mutex.Unlock()
+
+	delete(subscription.hub.subscriptions, subscription)
+}
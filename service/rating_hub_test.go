@@ -0,0 +1,36 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingHubOrder(t *testing.T) {
+	t.Parallel()
+
+	hub := service.NewRatingHub()
+	subscription := hub.Subscribe(nil)
+	defer subscription.Close()
+
+	// the ratings added concurrently can be published out of order
+	hub.Publish(&pb.RateLaptopResponse{LaptopId: "laptop-1", RatingCount: 1, AverageScore: 5})
+	hub.Publish(&pb.RateLaptopResponse{LaptopId: "laptop-1", RatingCount: 3, AverageScore: 7})
+	hub.Publish(&pb.RateLaptopResponse{LaptopId: "laptop-1", RatingCount: 2, AverageScore: 6})
+
+	rating, err := subscription.Next(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.GetRatingCount())
+
+	// the older update is not sent after the latest one
+	hub.Publish(&pb.RateLaptopResponse{LaptopId: "laptop-1", RatingCount: 2, AverageScore: 6})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = subscription.Next(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
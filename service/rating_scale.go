@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidScore is returned when a score is not allowed by the rating scale.
+var ErrInvalidScore = errors.New("invalid score")
+
+// DefaultRatingScale accepts any score from 1 to 10.
+var DefaultRatingScale = RatingScale{Min: 1, Max: 10}
+
+// A RatingScale defines the range of scores a laptop can be rated with.
+type RatingScale struct {
+	Min float64
+	Max float64
+	// Step is the increment allowed between two scores, e.g. 0.5 for half stars.
+	// A zero step accepts any score within the range.
+	Step float64
+}
+
+// NewRatingScale checks the scale parameters and returns a new RatingScale.
+func NewRatingScale(min, max, step float64) (RatingScale, error) {
+	scale := RatingScale{Min: min, Max: max, Step: step}
+	for _, value := range []float64{min, max, step} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return RatingScale{}, fmt.Errorf("rating scale values must be finite")
+		}
+	}
+	if min >= max {
+		return RatingScale{}, fmt.Errorf("rating scale min %g must be less than max %g", min, max)
+	}
+	if step < 0 || step > max-min {
+		return RatingScale{}, fmt.Errorf("rating scale step %g is out of range", step)
+	}
+	if step > 0 && !scale.onStep(max) {
+		return RatingScale{}, fmt.Errorf("rating scale max %g is not reachable with step %g", max, step)
+	}
+	return scale, nil
+}
+
+// Validate returns ErrInvalidScore if the score is not allowed by the scale.
+func (scale RatingScale) Validate(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("%w: score must be a finite number", ErrInvalidScore)
+	}
+	if score < scale.Min || score > scale.Max {
+		return fmt.Errorf("%w: score %g is out of range [%g, %g]", ErrInvalidScore, score, scale.Min, scale.Max)
+	}
+	if scale.Step > 0 && !scale.onStep(score) {
+		return fmt.Errorf("%w: score %g is not a multiple of %g from %g", ErrInvalidScore, score, scale.Step, scale.Min)
+	}
+	return nil
+}
+
+func (scale RatingScale) onStep(score float64) bool {
+	steps := (score - scale.Min) / scale.Step
+	return math.Abs(steps-math.Round(steps)) < 1e-9
+}
+
+// String returns the scale in the min:max:step form accepted by ParseRatingScale.
+func (scale RatingScale) String() string {
+	return fmt.Sprintf("%g:%g:%g", scale.Min, scale.Max, scale.Step)
+}
+
+// ParseRatingScale parses a scale written as min:max or min:max:step, e.g. "1:5:0.5".
+func ParseRatingScale(value string) (RatingScale, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return RatingScale{}, fmt.Errorf("cannot parse rating scale %q: expected min:max[:step]", value)
+	}
+
+	values := make([]float64, 3)
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return RatingScale{}, fmt.Errorf("cannot parse rating scale %q: %w", value, err)
+		}
+		values[i] = number
+	}
+
+	return NewRatingScale(values[0], values[1], values[2])
+}
@@ -1,34 +1,71 @@
 package service
 
 import (
+	"math"
 	"sync"
+	"time"
 )
 
+// DefaultRatingHalfLife is the age at which a score counts half as much as a new one.
+const DefaultRatingHalfLife = 30 * 24 * time.Hour
+
 // RatingScore is an interface to store laptop ratings.
 type RatingStore interface {
 	// Add adds a new laptop score to the store and returns its rating.
 	Add(laptopID string, score float64) (*Rating, error)
+	// Find returns the rating of a laptop, or nil if it has not been rated yet.
+	Find(laptopID string) (*Rating, error)
 }
 
 // A Rating contains the rating information of a laptop.
 type Rating struct {
 	Count uint32
 	Sum   float64
+	// DecayedCount and DecayedSum weight every score by its age at UpdatedAt,
+	// so that older scores count less than recent ones.
+	DecayedCount float64
+	DecayedSum   float64
+	UpdatedAt    time.Time
+}
+
+// add adds a score given at time now, decaying the previous scores with the given half-life.
+func (rating *Rating) add(score float64, now time.Time, halfLife time.Duration) {
+	decay := 1.0
+	if rating.Count > 0 && halfLife > 0 {
+		age := now.Sub(rating.UpdatedAt)
+		decay = math.Exp2(-float64(age) / float64(halfLife))
+	}
+
+	rating.Count++
+	rating.Sum += score
+	rating.DecayedCount = rating.DecayedCount*decay + 1
+	rating.DecayedSum = rating.DecayedSum*decay + score
+	rating.UpdatedAt = now
 }
 
 // InMemoryRatingStore stores laptop ratings in memory
 type InMemoryRatingStore struct {
-	mutex  sync.RWMutex
-	rating map[string]*Rating
+	mutex    sync.RWMutex
+	rating   map[string]*Rating
+	halfLife time.Duration
 }
 
 // NewInMemoryLaptopStore returns a new InMemoryLaptopStore instance.
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
-		rating: make(map[string]*Rating),
+		rating:   make(map[string]*Rating),
+		halfLife: DefaultRatingHalfLife,
 	}
 }
 
+// SetHalfLife changes the half-life of the time-decayed scores added from now on.
+func (store *InMemoryRatingStore) SetHalfLife(halfLife time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.halfLife = halfLife
+}
+
 // Add adds a new laptop to the store and returns its rating
 func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
 	store.mutex.Lock()
@@ -36,15 +73,25 @@ func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating,
 
 	rating := store.rating[laptopID]
 	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
-		}
-	} else {
-		rating.Count++
-		rating.Sum += score
+		rating = &Rating{}
 	}
+	rating.add(score, time.Now(), store.halfLife)
 
 	store.rating[laptopID] = rating
-	return rating, nil
+	copy := *rating
+	return &copy, nil
+}
+
+// Find returns the rating of a laptop, or nil if it has not been rated yet.
+func (store *InMemoryRatingStore) Find(laptopID string) (*Rating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	rating := store.rating[laptopID]
+	if rating == nil {
+		return nil, nil
+	}
+
+	copy := *rating
+	return &copy, nil
 }
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// A RevocationStore is an interface to store the IDs of revoked tokens.
+type RevocationStore interface {
+	// Revoke marks a token as revoked until it expires, and reports whether it was
+	// already revoked. Concurrent calls for the same token report it once as not revoked.
+	Revoke(tokenID string, expiresAt time.Time) (alreadyRevoked bool, err error)
+	// IsRevoked reports whether a token has been revoked.
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// DefaultRevocationSweepInterval is the interval at which the expired tokens are forgotten.
+const DefaultRevocationSweepInterval = time.Minute
+
+// InMemoryRevocationStore stores revoked token IDs in memory.
+type InMemoryRevocationStore struct {
+	mutex         sync.RWMutex
+	revoked       map[string]time.Time
+	sweepInterval time.Duration
+	lastSweep     time.Time
+}
+
+// NewInMemoryRevocationStore returns a new InMemoryRevocationStore.
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		revoked:       make(map[string]time.Time),
+		sweepInterval: DefaultRevocationSweepInterval,
+	}
+}
+
+// SetSweepInterval changes the interval at which the expired tokens are forgotten.
+func (store *InMemoryRevocationStore) SetSweepInterval(interval time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sweepInterval = interval
+}
+
+// Revoke marks a token as revoked until it expires, and reports whether it was already revoked.
+// Tokens that have already expired are forgotten, since they cannot be used anymore.
+func (store *InMemoryRevocationStore) Revoke(tokenID string, expiresAt time.Time) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sweep(time.Now())
+
+	if _, ok := store.revoked[tokenID]; ok {
+		return true, nil
+	}
+
+	store.revoked[tokenID] = expiresAt
+	return false, nil
+}
+
+// IsRevoked reports whether a token has been revoked.
+func (store *InMemoryRevocationStore) IsRevoked(tokenID string) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	_, ok := store.revoked[tokenID]
+	return ok, nil
+}
+
+// sweep forgets the expired tokens, at most once every sweep interval, so that a
+// revocation does not scan them all.
+func (store *InMemoryRevocationStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < store.sweepInterval {
+		return
+	}
+	store.lastSweep = now
+
+	for id, expiry := range store.revoked {
+		if expiry.Before(now) {
+			delete(store.revoked, id)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultTenant is the tenant of the users, API keys and laptops created without one.
+const DefaultTenant = "default"
+
+// AllTenants selects every tenant, for the callers with the tenant:admin permission.
+const AllTenants = "*"
+
+// TenantHeader is the metadata key selecting the tenant of an RPC, for the callers
+// with the tenant:admin permission. Other callers act on their own tenant.
+const TenantHeader = "x-tenant"
+
+// tenant names are also used as image folder names
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateTenant checks that a tenant name is made of lowercase letters, digits and dashes.
+func ValidateTenant(tenant string) error {
+	if !tenantNamePattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant name %q", tenant)
+	}
+	return nil
+}
+
+// TenantFromContext returns the tenant selected for the principal stored in ctx,
+// or the DefaultTenant if there is none.
+func TenantFromContext(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Tenant == "" {
+		return DefaultTenant
+	}
+	return principal.Tenant
+}
+
+// isCrossTenant reports whether the principal stored in ctx can act on any tenant.
+func isCrossTenant(ctx context.Context) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	return ok && principal.CrossTenant
+}
+
+// inTenant reports whether a record of tenant is visible from the tenant selected in ctx.
+func inTenant(ctx context.Context, tenant string) bool {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+
+	selected := TenantFromContext(ctx)
+	return selected == AllTenants || selected == tenant
+}
+
+// A TenantSet is the set of the known tenants.
+type TenantSet map[string]bool
+
+// NewTenantSet returns the set of the tenants, which always includes the DefaultTenant.
+func NewTenantSet(tenants ...string) (TenantSet, error) {
+	set := TenantSet{DefaultTenant: true}
+	for _, tenant := range tenants {
+		err := ValidateTenant(tenant)
+		if err != nil {
+			return nil, err
+		}
+		set[tenant] = true
+	}
+	return set, nil
+}
+
+// A TenantStoreFactory creates the stores of the laptop catalog of a tenant.
+type TenantStoreFactory func(tenant string) (LaptopStore, ImageStore, RatingStore, error)
+
+// tenantCatalog is the laptop catalog of a tenant, isolated from the other tenants.
+type tenantCatalog struct {
+	tenant      string
+	laptopStore LaptopStore
+	imageStore  ImageStore
+	ratingStore RatingStore
+	ratingHub   *RatingHub
+	// createMutex serializes the creation of laptops when their number is limited.
+	createMutex sync.Mutex
+}
+
+// tenantCatalogs holds the catalogs of the tenants, created on first use.
+type tenantCatalogs struct {
+	mutex    sync.Mutex
+	catalogs map[string]*tenantCatalog
+	factory  TenantStoreFactory
+	feed     *LaptopFeed
+	// tenants are the tenants that can have a catalog.
+	tenants TenantSet
+}
+
+func (catalogs *tenantCatalogs) add(
+	tenant string,
+	laptopStore LaptopStore,
+	imageStore ImageStore,
+	ratingStore RatingStore,
+) *tenantCatalog {
+	catalog := &tenantCatalog{
+		tenant:      tenant,
+		laptopStore: newTenantFeedLaptopStore(laptopStore, catalogs.feed, tenant),
+		imageStore:  imageStore,
+		ratingStore: ratingStore,
+		ratingHub:   NewRatingHub(),
+	}
+	catalogs.catalogs[tenant] = catalog
+	return catalog
+}
+
+// get returns the catalog of a known tenant, created with the factory if it does not exist yet.
+func (catalogs *tenantCatalogs) get(tenant string) (*tenantCatalog, error) {
+	catalogs.mutex.Lock()
+	defer catalogs.mutex.Unlock()
+
+	catalog := catalogs.catalogs[tenant]
+	if catalog != nil {
+		return catalog, nil
+	}
+
+	if !catalogs.tenants[tenant] {
+		return nil, fmt.Errorf("tenant %s is unknown", tenant)
+	}
+
+	if catalogs.factory == nil {
+		return nil, fmt.Errorf("tenant %s has no catalog", tenant)
+	}
+
+	laptopStore, imageStore, ratingStore, err := catalogs.factory(tenant)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create catalog of tenant %s: %w", tenant, err)
+	}
+
+	return catalogs.add(tenant, laptopStore, imageStore, ratingStore), nil
+}
+
+// all returns the existing catalogs ordered by tenant.
+func (catalogs *tenantCatalogs) all() []*tenantCatalog {
+	catalogs.mutex.Lock()
+	defer catalogs.mutex.Unlock()
+
+	all := make([]*tenantCatalog, 0, len(catalogs.catalogs))
+	for _, catalog := range catalogs.catalogs {
+		all = append(all, catalog)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].tenant < all[j].tenant
+	})
+	return all
+}
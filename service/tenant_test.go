@@ -0,0 +1,192 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := newTestJWTManager(t)
+	tenants, err := service.NewTenantSet("eu", "us")
+	require.NoError(t, err)
+
+	interceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+	interceptor.SetTenants(tenants)
+
+	var created []string
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore())
+	laptopServer.SetTenants(tenants)
+	laptopServer.SetTenantStoreFactory(func(tenant string) (service.LaptopStore, service.ImageStore, service.RatingStore, error) {
+		created = append(created, tenant)
+		return service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore(), nil
+	})
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	callContext := func(user *service.User, tenant string) context.Context {
+		token, err := jwtManager.GenerateToken(user)
+		require.NoError(t, err)
+
+		md := metadata.Pairs("authorization", token)
+		if tenant != "" {
+			md.Set(service.TenantHeader, tenant)
+		}
+		return metadata.NewOutgoingContext(context.Background(), md)
+	}
+
+	euAdmin := &service.User{Username: "eu-admin", Role: service.RoleAdmin, Tenant: "eu"}
+	usAdmin := &service.User{Username: "us-admin", Role: service.RoleAdmin, Tenant: "us"}
+	superAdmin := &service.User{Username: "root", Role: service.RoleSuperAdmin}
+
+	euLaptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(callContext(euAdmin, ""), &pb.CreateLaptopRequest{Laptop: euLaptop})
+	require.NoError(t, err)
+
+	usLaptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(callContext(usAdmin, ""), &pb.CreateLaptopRequest{Laptop: usLaptop})
+	require.NoError(t, err)
+
+	_, err = laptopClient.UpdateLaptop(callContext(usAdmin, ""), &pb.UpdateLaptopRequest{Laptop: euLaptop})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = laptopClient.DeleteLaptop(callContext(usAdmin, ""), &pb.DeleteLaptopRequest{Id: euLaptop.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	search := func(ctx context.Context) ([]*pb.SearchLaptopResponse, error) {
+		stream, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1e9}})
+		require.NoError(t, err)
+
+		var results []*pb.SearchLaptopResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return results, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, res)
+		}
+	}
+
+	results, err := search(callContext(euAdmin, ""))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, euLaptop.GetId(), results[0].GetLaptop().GetId())
+
+	_, err = search(callContext(euAdmin, "us"))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = search(callContext(euAdmin, service.AllTenants))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	results, err = search(callContext(superAdmin, "us"))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, usLaptop.GetId(), results[0].GetLaptop().GetId())
+
+	results, err = search(callContext(superAdmin, service.AllTenants))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	tenantOf := map[string]string{}
+	for _, res := range results {
+		tenantOf[res.GetLaptop().GetId()] = res.GetTenant()
+	}
+	require.Equal(t, map[string]string{euLaptop.GetId(): "eu", usLaptop.GetId(): "us"}, tenantOf)
+
+	_, err = search(callContext(superAdmin, "asia"))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = laptopClient.CreateLaptop(callContext(superAdmin, service.AllTenants), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the callers of an unknown tenant get no catalog
+	asiaAdmin := &service.User{Username: "asia-admin", Role: service.RoleAdmin, Tenant: "asia"}
+	_, err = laptopClient.CreateLaptop(callContext(asiaAdmin, ""), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.ElementsMatch(t, []string{"eu", "us"}, created)
+}
+
+func TestTenantUserManagement(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := newTestJWTManager(t)
+	tenants, err := service.NewTenantSet("eu")
+	require.NoError(t, err)
+
+	authServer := service.NewAuthServer(service.NewInMemoryUserStore(), jwtManager, service.NewInMemoryRevocationStore())
+	authServer.SetTenants(tenants)
+	authServer.SetPolicy(loadTestPolicy(t))
+	ctx := context.Background()
+
+	_, err = authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret", Tenant: "asia"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	registered, err := authServer.Register(ctx, &pb.RegisterRequest{Username: "alice", Password: "alice-secret", Tenant: "eu"})
+	require.NoError(t, err)
+	require.Equal(t, "eu", registered.GetUser().GetTenant())
+
+	_, err = authServer.Register(ctx, &pb.RegisterRequest{Username: "bob", Password: "bob-secret"})
+	require.NoError(t, err)
+
+	login, err := authServer.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+	claims, err := jwtManager.Verify(login.GetAccessToken())
+	require.NoError(t, err)
+	require.Equal(t, "eu", claims.Tenant)
+
+	euAdminCtx := service.ContextWithPrincipal(ctx, &service.Principal{Username: "eu-admin", Role: service.RoleAdmin, Tenant: "eu"})
+
+	users, err := authServer.ListUsers(euAdminCtx, &pb.ListUsersRequest{})
+	require.NoError(t, err)
+	require.Len(t, users.GetUsers(), 1)
+	require.Equal(t, "alice", users.GetUsers()[0].GetUsername())
+
+	_, err = authServer.DisableUser(euAdminCtx, &pb.DisableUserRequest{Username: "bob"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = authServer.UpdateUserRole(euAdminCtx, &pb.UpdateUserRoleRequest{Username: "alice", Role: service.RoleSuperAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = authServer.CreateUser(euAdminCtx, &pb.CreateUserRequest{Username: "eve", Password: "eve-secret", Role: service.RoleSuperAdmin})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	created, err := authServer.CreateUser(euAdminCtx, &pb.CreateUserRequest{Username: "carol", Password: "carol-secret", Role: service.RoleAdmin})
+	require.NoError(t, err)
+	require.Equal(t, "eu", created.GetUser().GetTenant())
+
+	superAdminCtx := service.ContextWithPrincipal(ctx, &service.Principal{
+		Username:    "root",
+		Role:        service.RoleSuperAdmin,
+		Tenant:      service.AllTenants,
+		CrossTenant: true,
+	})
+
+	users, err = authServer.ListUsers(superAdminCtx, &pb.ListUsersRequest{})
+	require.NoError(t, err)
+	require.Len(t, users.GetUsers(), 3)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// A TLSReloader holds the server certificate and the client CAs loaded from files, and
+// replaces them at once when the files are reloaded. The next handshakes use the new
+// files, while the established connections are not disturbed.
+type TLSReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	files atomic.Pointer[tlsFiles]
+}
+
+// tlsFiles are the loaded certificate and client CAs.
+type tlsFiles struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// NewTLSReloader returns a TLSReloader loading the server certificate and private key
+// from certFile and keyFile, and the CA certificates verifying the client certificates
+// from clientCAFile, unless it is empty.
+func NewTLSReloader(certFile, keyFile, clientCAFile string) (*TLSReloader, error) {
+	reloader := &TLSReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Files returns the files loaded by the reloader.
+func (reloader *TLSReloader) Files() []string {
+	files := []string{reloader.certFile, reloader.keyFile}
+	if reloader.clientCAFile != "" {
+		files = append(files, reloader.clientCAFile)
+	}
+	return files
+}
+
+// Reload loads the files again. The current certificate and client CAs are kept if
+// a file cannot be loaded.
+func (reloader *TLSReloader) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if reloader.clientCAFile != "" {
+		pemClientCA, err := os.ReadFile(reloader.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("cannot read client CA's certificate: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pemClientCA) {
+			return fmt.Errorf("failed to add client CA's certificate")
+		}
+	}
+
+	reloader.files.Store(&tlsFiles{
+		certificate: &certificate,
+		clientCAs:   clientCAs,
+	})
+	return nil
+}
+
+// Config returns a TLS config with the settings of base, such as ClientAuth and
+// NextProtos, using the current certificate and client CAs for every handshake.
+func (reloader *TLSReloader) Config(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return reloader.files.Load().certificate, nil
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		files := reloader.files.Load()
+
+		handshakeConfig := base.Clone()
+		handshakeConfig.Certificates = []tls.Certificate{*files.certificate}
+		handshakeConfig.ClientCAs = files.clientCAs
+		return handshakeConfig, nil
+	}
+	return config
+}
@@ -0,0 +1,148 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSReloader(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	certFile := filepath.Join(folder, "server-cert.pem")
+	keyFile := filepath.Join(folder, "server-key.pem")
+	writeTestServerCertificate(t, certFile, keyFile, "server-1")
+
+	reloader, err := service.NewTLSReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{certFile, keyFile}, reloader.Files())
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.Config(&tls.Config{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	conn1, commonName := dialTestTLS(t, listener.Addr().String())
+	require.Equal(t, "server-1", commonName)
+
+	// the new handshakes use the reloaded certificate
+	writeTestServerCertificate(t, certFile, keyFile, "server-2")
+	err = reloader.Reload()
+	require.NoError(t, err)
+
+	_, commonName = dialTestTLS(t, listener.Addr().String())
+	require.Equal(t, "server-2", commonName)
+
+	// the established connections are not disturbed
+	_, err = conn1.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn1, reply)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(reply))
+
+	// the current certificate is kept if the files cannot be loaded
+	err = os.WriteFile(certFile, []byte("not a certificate"), 0o600)
+	require.NoError(t, err)
+	err = reloader.Reload()
+	require.Error(t, err)
+
+	_, commonName = dialTestTLS(t, listener.Addr().String())
+	require.Equal(t, "server-2", commonName)
+}
+
+func TestWatchFiles(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(file, []byte("{}"), 0o600)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	changes := make(chan struct{}, 10)
+	service.WatchFiles(ctx, 10*time.Millisecond, []string{file}, func() {
+		changes <- struct{}{}
+	})
+
+	requireChange := func() {
+		select {
+		case <-changes:
+		case <-time.After(time.Second):
+			require.Fail(t, "file change not detected")
+		}
+	}
+
+	// let the watcher record the current version of the file
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, changes)
+
+	err = os.WriteFile(file, []byte(`{"roles": {}}`), 0o600)
+	require.NoError(t, err)
+	requireChange()
+
+	err = os.Remove(file)
+	require.NoError(t, err)
+	requireChange()
+}
+
+// dialTestTLS opens a TLS connection to address, and returns it with the common name
+// of the server certificate.
+func dialTestTLS(t *testing.T, address string) (*tls.Conn, string) {
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// writeTestServerCertificate writes a new self-signed certificate and its private key.
+func writeTestServerCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	require.NoError(t, err)
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	require.NoError(t, err)
+}
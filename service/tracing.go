@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TracerName is the name of the tracer of the spans started by this package.
+const TracerName = "github.com/IkehAkinyemi/pcbook/service"
+
+// MetadataCarrier adapts gRPC metadata to carry a trace context.
+type MetadataCarrier metadata.MD
+
+// Get returns the first value of the key.
+func (carrier MetadataCarrier) Get(key string) string {
+	values := metadata.MD(carrier).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set replaces the values of the key.
+func (carrier MetadataCarrier) Set(key string, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+// Keys returns the keys of the metadata.
+func (carrier MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// TracingInterceptor is a server interceptor starting a span for every RPC, as a child
+// of the trace context propagated in the metadata. It must run before the other
+// interceptors, so that their spans are part of the RPC's trace.
+type TracingInterceptor struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracingInterceptor returns a new TracingInterceptor starting spans with the tracers
+// of provider, and extracting the trace context from the metadata with propagator.
+func NewTracingInterceptor(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *TracingInterceptor {
+	return &TracingInterceptor{
+		tracer:     provider.Tracer(TracerName),
+		propagator: propagator,
+	}
+}
+
+// Unary returns a server interceptor function to trace unary RPC.
+func (interceptor *TracingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := interceptor.start(ctx, info.FullMethod)
+		res, err := handler(ctx, req)
+
+		endRPCSpan(span, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to trace stream RPC.
+func (interceptor *TracingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := interceptor.start(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStreamWithContext{ss, ctx})
+
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+func (interceptor *TracingInterceptor) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		ctx = interceptor.propagator.Extract(ctx, MetadataCarrier(md))
+	}
+
+	return interceptor.tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(RPCAttributes(method)...),
+		trace.WithAttributes(attribute.String("net.sock.peer.addr", peerIP(ctx))),
+	)
+}
+
+// RPCAttributes returns the span attributes of a call to the method, given by its full name.
+func RPCAttributes(method string) []attribute.KeyValue {
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", name),
+	}
+}
+
+// endRPCSpan ends the span of an RPC that returned err, recording its status code.
+func endRPCSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+	if code != codes.OK {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// startSpan starts a span as a child of the span of ctx, with the same tracer provider.
+// Without a span in ctx, the span is not recorded.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(TracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, recording err if it is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,147 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IkehAkinyemi/pcbook/gateway"
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/serializer"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	propagator := propagation.TraceContext{}
+
+	jwtManager := newTestJWTManager(t)
+	tracingInterceptor := service.NewTracingInterceptor(provider, propagator)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, service.NewInMemoryRevocationStore(), loadTestPolicy(t))
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracingInterceptor.Unary(), authInterceptor.Unary()),
+		grpc.ChainStreamInterceptor(tracingInterceptor.Stream(), authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	mux := runtime.NewServeMux()
+	dialOpts := append(
+		[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		gateway.TracingDialOptions(provider, propagator)...,
+	)
+	err = pb.RegisterLaptopServiceHandlerFromEndpoint(context.Background(), mux, listener.Addr().String(), dialOpts)
+	require.NoError(t, err)
+
+	restServer := httptest.NewServer(gateway.TraceHandler(provider, propagator, mux))
+	t.Cleanup(restServer.Close)
+
+	token, err := jwtManager.GenerateToken(&service.User{Username: "admin1", Role: service.RoleAdmin})
+	require.NoError(t, err)
+
+	body, err := serializer.ProtobufToJSON(&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	// the caller's trace continues through the gateway and the gRPC server
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, restServer.URL+"/v1/laptops/create", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		require.Equal(t, traceID, span.SpanContext.TraceID(), span.Name)
+		spans[span.Name] = span
+	}
+
+	httpSpan, ok := spans["HTTP POST"]
+	require.True(t, ok)
+	require.Equal(t, trace.SpanKindServer, httpSpan.SpanKind)
+
+	// the gateway and the gRPC server have a span with the same name
+	var clientSpan, serverSpan tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "LaptopService/CreateLaptop" && span.SpanKind == trace.SpanKindServer {
+			serverSpan = span
+		}
+		if span.Name == "LaptopService/CreateLaptop" && span.SpanKind == trace.SpanKindClient {
+			clientSpan = span
+		}
+	}
+	require.Equal(t, httpSpan.SpanContext.SpanID(), clientSpan.Parent.SpanID())
+	require.Equal(t, clientSpan.SpanContext.SpanID(), serverSpan.Parent.SpanID())
+	require.True(t, serverSpan.Parent.IsRemote())
+
+	authSpan, ok := spans["AuthInterceptor.authenticate"]
+	require.True(t, ok)
+	require.Equal(t, serverSpan.SpanContext.SpanID(), authSpan.Parent.SpanID())
+
+	saveSpan, ok := spans["LaptopStore.Save"]
+	require.True(t, ok)
+	require.Equal(t, serverSpan.SpanContext.SpanID(), saveSpan.Parent.SpanID())
+
+	// the store scan and the copies of the laptops found are traced
+	exporter.Reset()
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "search")
+	ctx = injectTraceContext(ctx, propagator, token)
+	stream, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1e9}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+	parent.End()
+
+	spans = make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID(), span.Name)
+		spans[span.Name] = span
+	}
+	searchSpan, ok := spans["LaptopStore.Search"]
+	require.True(t, ok)
+	copySpan, ok := spans["deepCopy"]
+	require.True(t, ok)
+	require.Equal(t, searchSpan.SpanContext.SpanID(), copySpan.Parent.SpanID())
+}
+
+// injectTraceContext returns a copy of ctx sending its trace context and the token
+// in the outgoing metadata.
+func injectTraceContext(ctx context.Context, propagator propagation.TextMapPropagator, token string) context.Context {
+	md := metadata.Pairs("authorization", token)
+	propagator.Inject(ctx, service.MetadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
@@ -1,37 +1,72 @@
 package service
 
-import (
-	"golang.org/x/crypto/bcrypt"
+// Roles of the users. Admins manage the catalog and the users of their own tenant,
+// and super admins those of every tenant.
+const (
+	RoleSuperAdmin = "super_admin"
+	RoleAdmin      = "admin"
+	RoleUser       = "user"
 )
 
+// IsValidRole reports whether role is one of the built-in user roles, the only ones
+// accepted without a policy. The policy can define other roles.
+func IsValidRole(role string) bool {
+	return role == RoleSuperAdmin || role == RoleAdmin || role == RoleUser
+}
+
 // A User defines user's information.
 type User struct {
 	Username       string
 	HashedPassword string
 	Role           string
+	// Tenant is the storefront of the user, the DefaultTenant if empty.
+	Tenant string
+	// Disabled users can no longer log in.
+	Disabled bool
 }
 
-// NewUser hashes the cleartext password and returns a User instance.
+// NewUser hashes the cleartext password with the DefaultPasswordHasher and returns a User instance.
 func NewUser(username, password string, role string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return NewUserWithHasher(username, password, role, DefaultPasswordHasher)
+}
+
+// NewUserWithHasher hashes the cleartext password with hasher and returns a User instance.
+func NewUserWithHasher(username, password string, role string, hasher PasswordHasher) (*User, error) {
+	user := &User{
+		Username: username,
+		Role:     role,
+	}
+
+	err := user.SetPasswordWithHasher(password, hasher)
 	if err != nil {
 		return nil, err
 	}
 
-	user := &User{
-		Username:       username,
-		HashedPassword: string(hashedPassword),
-		Role:           role,
+	return user, nil
+}
+
+// SetPassword hashes the cleartext password with the DefaultPasswordHasher and
+// replaces the user's password with it.
+func (user *User) SetPassword(password string) error {
+	return user.SetPasswordWithHasher(password, DefaultPasswordHasher)
+}
+
+// SetPasswordWithHasher hashes the cleartext password with hasher and replaces the
+// user's password with it.
+func (user *User) SetPasswordWithHasher(password string, hasher PasswordHasher) error {
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return err
 	}
 
-	return user, nil
+	user.HashedPassword = hashedPassword
+	return nil
 }
 
 // VerfiyPassword checks if the provided cleartext password
-// is correct or not
+// is correct or not, whichever hasher hashed it.
 func (user *User) VerfiyPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
-	return err == nil
+	return verifyPasswordHash(user.HashedPassword, password)
 }
 
 // Clone returns a clone of this user
@@ -40,5 +75,7 @@ func (user *User) Clone() *User {
 		Username:       user.Username,
 		HashedPassword: user.HashedPassword,
 		Role:           user.Role,
+		Tenant:         user.Tenant,
+		Disabled:       user.Disabled,
 	}
 }
@@ -1,6 +1,13 @@
 package service
 
-import "sync"
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrConflict is returned when a user was changed since it was read.
+var ErrConflict = errors.New("record was changed concurrently")
 
 // A UserStore defines a data store for users.
 type UserStore interface {
@@ -8,6 +15,17 @@ type UserStore interface {
 	Save(user *User) error
 	// Find finds a user by username.
 	Find(username string) (*User, error)
+	// Update replaces a user saved with the same username.
+	Update(user *User) error
+	// UpdatePassword replaces the password hash of a user, if it is still oldHash.
+	UpdatePassword(username, oldHash, newHash string) error
+	// Modify changes a user with the modify function, atomically, and returns the
+	// changed user. The user is not changed if modify returns an error.
+	Modify(username string, modify func(user *User) error) (*User, error)
+	// Delete deletes a user by username.
+	Delete(username string) error
+	// List returns all users ordered by username.
+	List() ([]*User, error)
 }
 
 // A InMemoryUserStore stores users in memory.
@@ -39,7 +57,7 @@ func (store *InMemoryUserStore) Save(user *User) error {
 // Find finds a user by username.
 func (store *InMemoryUserStore) Find(username string) (*User, error) {
 	store.mutex.RLock()
-	defer store.mutex.RLock()
+	defer store.mutex.RUnlock()
 
 	user := store.users[username]
 	if user == nil {
@@ -48,3 +66,85 @@ func (store *InMemoryUserStore) Find(username string) (*User, error) {
 
 	return user.Clone(), nil
 }
+
+// Update replaces a user saved with the same username.
+func (store *InMemoryUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] == nil {
+		return ErrNotFound
+	}
+
+	store.users[user.Username] = user.Clone()
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user, if it is still oldHash.
+// It returns ErrConflict if the hash was changed in the meantime.
+func (store *InMemoryUserStore) UpdatePassword(username, oldHash, newHash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user := store.users[username]
+	if user == nil {
+		return ErrNotFound
+	}
+
+	if user.HashedPassword != oldHash {
+		return ErrConflict
+	}
+
+	user.HashedPassword = newHash
+	return nil
+}
+
+// Modify changes a user with the modify function, atomically, and returns the changed user.
+// The user is not changed if modify returns an error.
+func (store *InMemoryUserStore) Modify(username string, modify func(user *User) error) (*User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user := store.users[username]
+	if user == nil {
+		return nil, ErrNotFound
+	}
+
+	modified := user.Clone()
+	err := modify(modified)
+	if err != nil {
+		return nil, err
+	}
+
+	store.users[username] = modified
+	return modified.Clone(), nil
+}
+
+// Delete deletes a user by username.
+func (store *InMemoryUserStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[username] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.users, username)
+	return nil
+}
+
+// List returns all users ordered by username.
+func (store *InMemoryUserStore) List() ([]*User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	users := make([]*User, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, user.Clone())
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/serializer"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Headers set on every webhook delivery.
+const (
+	WebhookEventHeader     = "X-Pcbook-Event"
+	WebhookDeliveryHeader  = "X-Pcbook-Delivery"
+	WebhookSignatureHeader = "X-Pcbook-Signature"
+)
+
+// maxDeadLetters is the number of failed deliveries kept by a WebhookDispatcher.
+const maxDeadLetters = 1000
+
+// WebhookOptions configures the delivery of webhooks.
+type WebhookOptions struct {
+	// MaxAttempts is the number of times a delivery is tried before it becomes a dead letter.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled after every attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits the duration of a single delivery attempt.
+	Timeout time.Duration
+}
+
+// DefaultWebhookOptions retries a delivery 5 times over about 15 seconds.
+var DefaultWebhookOptions = WebhookOptions{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Timeout:        10 * time.Second,
+}
+
+// A WebhookDispatcher delivers events to the webhooks that subscribed to them.
+// Deliveries are signed with the secret of the webhook, retried with exponential
+// backoff, and kept as dead letters once every attempt failed.
+type WebhookDispatcher struct {
+	store   WebhookStore
+	client  *http.Client
+	options WebhookOptions
+
+	mutex       sync.Mutex
+	deadLetters []*pb.DeadLetter
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher delivering events to the webhooks of store.
+func NewWebhookDispatcher(store WebhookStore, options WebhookOptions) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}
+}
+
+// Publish delivers an event in the background to every webhook that subscribed to its type.
+func (dispatcher *WebhookDispatcher) Publish(event *pb.WebhookEvent) {
+	if dispatcher == nil {
+		return
+	}
+
+	if event.Id == "" {
+		event.Id = uuid.NewString()
+	}
+	if event.Time == nil {
+		event.Time = timestamppb.Now()
+	}
+
+	webhooks, err := dispatcher.store.List()
+	if err != nil {
+		slog.Error("cannot list webhooks", "error", err)
+		return
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event) {
+			continue
+		}
+
+		if payload == nil {
+			data, err := serializer.ProtobufToJSON(event)
+			if err != nil {
+				slog.Error("cannot marshal webhook event", "event_id", event.GetId(), "error", err)
+				return
+			}
+			payload = []byte(data)
+		}
+
+		go dispatcher.deliver(webhook, event, payload)
+	}
+}
+
+// WatchLaptopFeed publishes in the background the events appended to the laptop feed
+// from now on, until the context is done.
+func (dispatcher *WebhookDispatcher) WatchLaptopFeed(ctx context.Context, feed *LaptopFeed) {
+	sequence := feed.LastSequence()
+	go dispatcher.watchLaptopFeed(ctx, feed, sequence)
+}
+
+func (dispatcher *WebhookDispatcher) watchLaptopFeed(ctx context.Context, feed *LaptopFeed, sequence uint64) {
+	eventTypes := map[pb.LaptopEvent_Type]pb.WebhookEvent_Type{
+		pb.LaptopEvent_CREATED: pb.WebhookEvent_LAPTOP_CREATED,
+		pb.LaptopEvent_UPDATED: pb.WebhookEvent_LAPTOP_UPDATED,
+		pb.LaptopEvent_DELETED: pb.WebhookEvent_LAPTOP_DELETED,
+	}
+
+	for {
+		events, changed, err := feed.Events(sequence)
+		if err != nil {
+			slog.Warn("webhooks missed laptop events", "sequence", sequence, "error", err)
+			sequence = feed.LastSequence()
+			continue
+		}
+
+		for _, event := range events {
+			dispatcher.Publish(&pb.WebhookEvent{
+				Type:    eventTypes[event.GetType()],
+				Time:    event.GetTime(),
+				Payload: &pb.WebhookEvent_Laptop{Laptop: event},
+				Tenant:  event.GetTenant(),
+			})
+			sequence = event.GetSequence()
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DeadLetters returns the deliveries that failed, optionally only those of a webhook.
+func (dispatcher *WebhookDispatcher) DeadLetters(webhookID string) []*pb.DeadLetter {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	var deadLetters []*pb.DeadLetter
+	for _, deadLetter := range dispatcher.deadLetters {
+		if webhookID == "" || deadLetter.GetWebhookId() == webhookID {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+	return deadLetters
+}
+
+func (dispatcher *WebhookDispatcher) deliver(webhook *Webhook, event *pb.WebhookEvent, payload []byte) {
+	backoff := dispatcher.options.InitialBackoff
+
+	var err error
+	for attempt := 1; attempt <= dispatcher.options.MaxAttempts; attempt++ {
+		err = dispatcher.post(webhook, event, payload)
+		if err == nil {
+			return
+		}
+		slog.Warn("cannot deliver webhook event", "event_id", event.GetId(), "webhook_id", webhook.ID, "attempt", attempt, "error", err)
+
+		if attempt < dispatcher.options.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > dispatcher.options.MaxBackoff {
+				backoff = dispatcher.options.MaxBackoff
+			}
+		}
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	dispatcher.deadLetters = append(dispatcher.deadLetters, &pb.DeadLetter{
+		WebhookId: webhook.ID,
+		Event:     event,
+		Attempts:  uint32(dispatcher.options.MaxAttempts),
+		LastError: err.Error(),
+		FailedAt:  timestamppb.Now(),
+	})
+	if len(dispatcher.deadLetters) > maxDeadLetters {
+		dispatcher.deadLetters = dispatcher.deadLetters[len(dispatcher.deadLetters)-maxDeadLetters:]
+	}
+}
+
+func (dispatcher *WebhookDispatcher) post(webhook *Webhook, event *pb.WebhookEvent, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.GetType().String())
+	req.Header.Set(WebhookDeliveryHeader, event.GetId())
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, payload))
+
+	res, err := dispatcher.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the signature header value of a payload: the hex-encoded
+// HMAC-SHA256 of the payload keyed with the webhook secret, prefixed with "sha256=".
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks in constant time that signature is the signature of the payload.
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, payload)), []byte(signature))
+}
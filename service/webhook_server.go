@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WebhookServer is the server to manage webhooks.
+type WebhookServer struct {
+	pb.UnimplementedWebhookServiceServer
+	webhookStore WebhookStore
+	dispatcher   *WebhookDispatcher
+}
+
+// NewWebhookServer returns a new WebhookServer.
+func NewWebhookServer(webhookStore WebhookStore, dispatcher *WebhookDispatcher) *WebhookServer {
+	return &WebhookServer{
+		webhookStore: webhookStore,
+		dispatcher:   dispatcher,
+	}
+}
+
+// RegisterWebhook registers an HTTP endpoint to receive the events of the caller's tenant,
+// or of all the tenants, and returns the secret that signs its deliveries.
+func (server *WebhookServer) RegisterWebhook(
+	ctx context.Context,
+	req *pb.RegisterWebhookRequest,
+) (*pb.RegisterWebhookResponse, error) {
+	endpoint, err := url.Parse(req.GetUrl())
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "webhook URL must be an absolute http(s) URL")
+	}
+
+	if len(req.GetEventTypes()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "webhook must subscribe to at least one event type")
+	}
+	for _, eventType := range req.GetEventTypes() {
+		if eventType == pb.WebhookEvent_UNKNOWN || pb.WebhookEvent_Type_name[int32(eventType)] == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event type: %v", eventType)
+		}
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate webhook secret: %v", err)
+	}
+
+	webhook := &Webhook{
+		ID:         uuid.NewString(),
+		URL:        endpoint.String(),
+		Secret:     hex.EncodeToString(secret),
+		EventTypes: req.GetEventTypes(),
+		Tenant:     TenantFromContext(ctx),
+		CreatedAt:  time.Now(),
+	}
+
+	err = server.webhookStore.Save(webhook)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save webhook: %v", err)
+	}
+
+	res := &pb.RegisterWebhookResponse{
+		Webhook: toPBWebhook(webhook),
+		Secret:  webhook.Secret,
+	}
+	return res, nil
+}
+
+// ListWebhooks returns the webhooks registered for the caller's tenant.
+func (server *WebhookServer) ListWebhooks(
+	ctx context.Context,
+	req *pb.ListWebhooksRequest,
+) (*pb.ListWebhooksResponse, error) {
+	webhooks, err := server.tenantWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.ListWebhooksResponse{}
+	for _, webhook := range webhooks {
+		res.Webhooks = append(res.Webhooks, toPBWebhook(webhook))
+	}
+	return res, nil
+}
+
+// DeleteWebhook stops the deliveries to a webhook of the caller's tenant.
+func (server *WebhookServer) DeleteWebhook(
+	ctx context.Context,
+	req *pb.DeleteWebhookRequest,
+) (*pb.DeleteWebhookResponse, error) {
+	webhookIDs, err := server.tenantWebhookIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !webhookIDs[req.GetId()] {
+		return nil, status.Errorf(codes.NotFound, "webhook %s is not found", req.GetId())
+	}
+
+	err = server.webhookStore.Delete(req.GetId())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, status.Errorf(code, "cannot delete webhook: %v", err)
+	}
+
+	return &pb.DeleteWebhookResponse{}, nil
+}
+
+// ListDeadLetters returns the events that could not be delivered to the webhooks of the caller's tenant.
+func (server *WebhookServer) ListDeadLetters(
+	ctx context.Context,
+	req *pb.ListDeadLettersRequest,
+) (*pb.ListDeadLettersResponse, error) {
+	webhookIDs, err := server.tenantWebhookIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.ListDeadLettersResponse{}
+	for _, deadLetter := range server.dispatcher.DeadLetters(req.GetWebhookId()) {
+		if webhookIDs[deadLetter.GetWebhookId()] {
+			res.DeadLetters = append(res.DeadLetters, deadLetter)
+		}
+	}
+	return res, nil
+}
+
+// tenantWebhooks returns the webhooks registered for the caller's tenant, in creation order.
+func (server *WebhookServer) tenantWebhooks(ctx context.Context) ([]*Webhook, error) {
+	webhooks, err := server.webhookStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list webhooks: %v", err)
+	}
+
+	tenant := TenantFromContext(ctx)
+	var tenantWebhooks []*Webhook
+	for _, webhook := range webhooks {
+		if tenant == AllTenants || webhook.Tenant == tenant {
+			tenantWebhooks = append(tenantWebhooks, webhook)
+		}
+	}
+	return tenantWebhooks, nil
+}
+
+// tenantWebhookIDs returns the set of the IDs of the webhooks registered for the caller's tenant.
+func (server *WebhookServer) tenantWebhookIDs(ctx context.Context) (map[string]bool, error) {
+	webhooks, err := server.tenantWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookIDs := make(map[string]bool, len(webhooks))
+	for _, webhook := range webhooks {
+		webhookIDs[webhook.ID] = true
+	}
+	return webhookIDs, nil
+}
+
+func toPBWebhook(webhook *Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:         webhook.ID,
+		Url:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		Tenant:     webhook.Tenant,
+		CreatedAt:  timestamppb.New(webhook.CreatedAt),
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+)
+
+// A Webhook is an HTTP endpoint that receives the events it subscribed to.
+type Webhook struct {
+	ID         string
+	URL        string
+	Secret     string
+	EventTypes []pb.WebhookEvent_Type
+	// Tenant is the tenant whose events are delivered, or AllTenants.
+	Tenant    string
+	CreatedAt time.Time
+}
+
+// Accepts reports whether the webhook subscribed to the type of the event,
+// and to the events of its tenant.
+func (webhook *Webhook) Accepts(event *pb.WebhookEvent) bool {
+	tenant := event.GetTenant()
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	if webhook.Tenant != AllTenants && webhook.Tenant != tenant {
+		return false
+	}
+
+	for _, t := range webhook.EventTypes {
+		if t == event.GetType() {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a clone of this webhook
+func (webhook *Webhook) Clone() *Webhook {
+	clone := *webhook
+	clone.EventTypes = append([]pb.WebhookEvent_Type(nil), webhook.EventTypes...)
+	return &clone
+}
+
+// A WebhookStore is an interface to store webhooks.
+type WebhookStore interface {
+	// Save saves a webhook to the store.
+	Save(webhook *Webhook) error
+	// Delete deletes a webhook by ID.
+	Delete(id string) error
+	// List returns all webhooks ordered by creation time.
+	List() ([]*Webhook, error)
+}
+
+// InMemoryWebhookStore stores webhooks in memory.
+type InMemoryWebhookStore struct {
+	mutex    sync.RWMutex
+	webhooks map[string]*Webhook
+}
+
+// NewInMemoryWebhookStore returns a new InMemoryWebhookStore.
+func NewInMemoryWebhookStore() *InMemoryWebhookStore {
+	return &InMemoryWebhookStore{
+		webhooks: make(map[string]*Webhook),
+	}
+}
+
+// Save saves a webhook to the store.
+func (store *InMemoryWebhookStore) Save(webhook *Webhook) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[webhook.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.webhooks[webhook.ID] = webhook.Clone()
+	return nil
+}
+
+// Delete deletes a webhook by ID.
+func (store *InMemoryWebhookStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[id] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.webhooks, id)
+	return nil
+}
+
+// List returns all webhooks ordered by creation time.
+func (store *InMemoryWebhookStore) List() ([]*Webhook, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(store.webhooks))
+	for _, webhook := range store.webhooks {
+		webhooks = append(webhooks, webhook.Clone())
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IkehAkinyemi/pcbook/pb"
+	"github.com/IkehAkinyemi/pcbook/sample"
+	"github.com/IkehAkinyemi/pcbook/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+
+	deliveries := make(chan webhookDelivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		deliveries <- webhookDelivery{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	webhookStore := service.NewInMemoryWebhookStore()
+	dispatcher := service.NewWebhookDispatcher(webhookStore, service.DefaultWebhookOptions)
+	webhookServer := service.NewWebhookServer(webhookStore, dispatcher)
+
+	res, err := webhookServer.RegisterWebhook(context.Background(), &pb.RegisterWebhookRequest{
+		Url:        receiver.URL,
+		EventTypes: []pb.WebhookEvent_Type{pb.WebhookEvent_LAPTOP_CREATED},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.GetSecret())
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcher.WatchLaptopFeed(ctx, laptopServer.LaptopFeed())
+
+	laptop := sample.NewLaptop()
+	_, err = laptopServer.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	select {
+	case delivery := <-deliveries:
+		signature := delivery.header.Get(service.WebhookSignatureHeader)
+		require.True(t, service.VerifyWebhookSignature(res.GetSecret(), delivery.body, signature))
+		require.False(t, service.VerifyWebhookSignature("other-secret", delivery.body, signature))
+		require.Equal(t, "LAPTOP_CREATED", delivery.header.Get(service.WebhookEventHeader))
+
+		event := &pb.WebhookEvent{}
+		err := protojson.Unmarshal(delivery.body, event)
+		require.NoError(t, err)
+		require.Equal(t, delivery.header.Get(service.WebhookDeliveryHeader), event.GetId())
+		require.Equal(t, laptop.GetId(), event.GetLaptop().GetLaptopId())
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	attempts := make(chan struct{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	options := service.WebhookOptions{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Timeout:        time.Second,
+	}
+	webhookStore := service.NewInMemoryWebhookStore()
+	dispatcher := service.NewWebhookDispatcher(webhookStore, options)
+	webhookServer := service.NewWebhookServer(webhookStore, dispatcher)
+
+	res, err := webhookServer.RegisterWebhook(context.Background(), &pb.RegisterWebhookRequest{
+		Url:        receiver.URL,
+		EventTypes: []pb.WebhookEvent_Type{pb.WebhookEvent_RATING_ADDED},
+	})
+	require.NoError(t, err)
+
+	dispatcher.Publish(&pb.WebhookEvent{
+		Type:    pb.WebhookEvent_LAPTOP_CREATED,
+		Payload: &pb.WebhookEvent_Laptop{Laptop: &pb.LaptopEvent{}},
+	})
+	dispatcher.Publish(&pb.WebhookEvent{
+		Type:    pb.WebhookEvent_RATING_ADDED,
+		Payload: &pb.WebhookEvent_Rating{Rating: &pb.RateLaptopResponse{LaptopId: "laptop-id"}},
+	})
+
+	require.Eventually(t, func() bool {
+		deadLetters, err := webhookServer.ListDeadLetters(context.Background(), &pb.ListDeadLettersRequest{})
+		require.NoError(t, err)
+		return len(deadLetters.GetDeadLetters()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Len(t, attempts, options.MaxAttempts)
+
+	deadLetters, err := webhookServer.ListDeadLetters(context.Background(), &pb.ListDeadLettersRequest{
+		WebhookId: res.GetWebhook().GetId(),
+	})
+	require.NoError(t, err)
+	require.Len(t, deadLetters.GetDeadLetters(), 1)
+
+	deadLetter := deadLetters.GetDeadLetters()[0]
+	require.Equal(t, uint32(options.MaxAttempts), deadLetter.GetAttempts())
+	require.Equal(t, "laptop-id", deadLetter.GetEvent().GetRating().GetLaptopId())
+	require.Contains(t, deadLetter.GetLastError(), "503")
+}
@@ -0,0 +1,9 @@
+// Package swagger embeds the OpenAPI specifications generated from the protos.
+package swagger
+
+import "embed"
+
+// Files holds the *.swagger.json specifications.
+//
+//go:embed *.swagger.json
+var Files embed.FS